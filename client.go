@@ -0,0 +1,81 @@
+package simple_registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Client of registry and storages, each client has its own database connection and local caches
+type Client struct {
+	// Registry of this client
+	Registry Interface
+	// Storages of this client
+	Storages *storages
+
+	db Database
+}
+
+// New client with config, sync services info from database and build local caches.
+// if *Instance is provided will be register automatically.
+// if context is done, watch loop will stop and local cache won't be updated anymore.
+func New(ctx context.Context, config Config, ins ...*Instance) (cli *Client, err error) {
+	config.check()
+	db, err := newDatabase(ctx, config)
+	if err != nil {
+		return
+	}
+
+	// create registry instance
+	reg, err := newRegistry(ctx, config, db)
+	if err != nil {
+		_ = db.Close()
+		return
+	}
+	// collect instance info and register
+	if len(ins) > 0 && ins[0] != nil {
+		if err = reg.register(ctx, ins[0].fillInfo(config.Locality).clone()); err != nil {
+			// stop watching and close connections, New can be retried
+			reg.stop()
+			_ = db.Close()
+			return
+		}
+	}
+
+	cli = &Client{
+		Registry: reg,
+		// create Storages instance
+		Storages: newStorages(ctx, config, db),
+		db:       db,
+	}
+	return
+}
+
+// Shutdown registry gracefully, see Interface.Shutdown, stop watching storages and close database.
+// local caches won't be updated anymore after shutdown.
+func (c *Client) Shutdown(ctx context.Context, drain time.Duration) (err error) {
+	err = c.Registry.Shutdown(ctx, drain)
+	c.Storages.stop()
+	if c.db != nil {
+		if e := c.db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func newDatabase(ctx context.Context, config Config) (db Database, err error) {
+	switch config.Type {
+	case TypeEtcd:
+		db, err = newEtcd(ctx, config.Database)
+	case TypeConsul:
+		db, err = newConsul(ctx, config.Database)
+	case TypeNacos:
+		db, err = newNacos(ctx, config)
+	case TypeMemory:
+		db, err = newMemory(ctx, config.Database)
+	default:
+		err = fmt.Errorf("unknown registry type \"%s\"", config.Type)
+	}
+	return
+}
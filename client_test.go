@@ -0,0 +1,155 @@
+package simple_registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	cfg1 := Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}, Prefix: "/prefix1/"}
+	cfg2 := cfg1
+	cfg2.Prefix = "/prefix2/"
+
+	cli1, err := New(ctx, cfg1, NewInstance("svc1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli2, err := New(ctx, cfg2, NewInstance("svc2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cli1.Registry.GetService(ctx, "svc1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli1.Registry.GetService(ctx, "svc2"); err != ErrServiceNotFound {
+		t.Fatalf("service of other prefix visible: %v", err)
+	}
+	if _, err = cli2.Registry.GetService(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cli1.Storages.GetStorage("test").Set(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ := cli2.Storages.GetStorage("test").Get(ctx, "key"); len(kvs) != 0 {
+		t.Fatalf("storage of other prefix visible: %+v", kvs)
+	}
+}
+
+func TestInitRetry(t *testing.T) {
+	resetGlobals()
+	cfg := getConfig()
+	cfg.Type = "unknown"
+	if err := Init(context.Background(), cfg); err == nil {
+		t.Fatal("expect unknown type error")
+	}
+	if Registry != nil || Storages != nil {
+		t.Fatal("globals set after failed init")
+	}
+	if err := Init(context.Background(), getConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if Registry == nil || Storages == nil {
+		t.Fatal("globals not set after init")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}}
+	peer, err := New(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := New(ctx, cfg, NewInstance("svc").WithAddress("127.0.0.1", 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	events := make(chan EventType, 10)
+	peer.Registry.RegisterEventHandler(func(i *Instance, e EventType) {
+		if e == EventTypeUpdate && i.Status != InstanceStatusDraining {
+			t.Errorf("unexpected status: %s", i.Status)
+		}
+		events <- e
+	})
+	start := time.Now()
+	if err = cli.Shutdown(ctx, time.Millisecond*300); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < time.Millisecond*300 {
+		t.Fatal("shutdown without drain")
+	}
+	// draining before deleted
+	for _, typ := range []EventType{EventTypeUpdate, EventTypeDelete} {
+		select {
+		case e := <-events:
+			if e != typ {
+				t.Fatalf("expect %s event, got %s", typ, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait %s event timeout", typ)
+		}
+	}
+
+	// lease revoked and watchers of client stopped
+	time.Sleep(time.Millisecond * 100)
+	store := cli.Registry.(*registry).cli.(*memory).store
+	store.mu.Lock()
+	leases, watchers := len(store.leases), len(store.watchers)
+	store.mu.Unlock()
+	if leases != 0 {
+		t.Fatalf("lease not revoked: %d", leases)
+	}
+	if watchers != 2 {
+		t.Fatalf("expect watchers of peer only, got %d", watchers)
+	}
+}
+
+func TestNew_RegisterFailed(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}}
+	ins := NewInstance("svc").WithAddress("127.0.0.1", 8080)
+	cli, err := New(ctx, cfg, ins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = New(ctx, cfg, ins); err != ErrAlreadyRegistered {
+		t.Fatalf("expect already registered, got %v", err)
+	}
+
+	// watchers of failed client stopped
+	time.Sleep(time.Millisecond * 100)
+	store := cli.Registry.(*registry).cli.(*memory).store
+	store.mu.Lock()
+	watchers := len(store.watchers)
+	store.mu.Unlock()
+	if watchers != 2 {
+		t.Fatalf("expect watchers of first client only, got %d", watchers)
+	}
+}
+
+func TestShutdown_Global(t *testing.T) {
+	resetGlobals()
+	ctx := context.Background()
+	if err := Init(ctx, getConfig()); err != nil {
+		t.Fatal(err)
+	}
+	old := Registry
+	if err := Shutdown(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if Registry != nil || Storages != nil {
+		t.Fatal("globals not reset after shutdown")
+	}
+	if err := Init(ctx, getConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if Registry == nil || Registry == old {
+		t.Fatal("init again not take effect after shutdown")
+	}
+}
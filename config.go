@@ -3,10 +3,20 @@ package simple_registry
 import (
 	"crypto/tls"
 	"fmt"
+	"os"
 )
 
 var (
-	TypeEtcd = "etcd"
+	TypeEtcd   = "etcd"
+	TypeConsul = "consul"
+	TypeNacos  = "nacos"
+	TypeMemory = "memory"
+)
+
+// environment variables of default locality
+const (
+	EnvRegion = "REGISTRY_REGION"
+	EnvZone   = "REGISTRY_ZONE"
 )
 
 type (
@@ -17,21 +27,35 @@ type (
 		Storage           StorageConfig  `json:"storage"`
 		Prefix            string         `json:"prefix"`              // start with "/",and end with "/" in etcd
 		HeartBeatInterval int64          `json:"heart_beat_interval"` // default 3s
+		Delivery          DeliveryConfig `json:"delivery"`            // default event delivery of handlers and watchers
+		Locality          Locality       `json:"locality"`            // default locality of instances, env REGISTRY_REGION and REGISTRY_ZONE if empty
+	}
+	// Locality of instance
+	Locality struct {
+		Region string `json:"region"`
+		Zone   string `json:"zone"`
 	}
 	// StorageConfig for storage module
 	StorageConfig struct {
 		Separator string `json:"separator"`
 	}
 	// DatabaseConfig for etcd,consul,nacos...
+	// memory databases with the same endpoints share data in process
 	DatabaseConfig struct {
 		// common
 		Endpoints []string `json:"endpoints"`
 		Username  string   `json:"username"`
-		Password  string   `json:"password"`
+		Password  string   `json:"password"` // acl token in consul
 		// etcd tls
 		Tls *TlsConfig `json:"tls"`
 	}
 
+	// DeliveryConfig of events to each handler or watcher, events are delivered in order
+	DeliveryConfig struct {
+		BufferSize int            `json:"buffer_size"` // pending events, default 1024
+		Overflow   OverflowPolicy `json:"overflow"`    // policy if buffer full, default coalesce
+	}
+
 	// TlsConfig ...
 	TlsConfig struct {
 		InsecureSkipVerify bool `json:"insecure_skip_verify"`
@@ -57,6 +81,23 @@ func (c *Config) check() {
 	if c.Storage.Separator == "" {
 		c.Storage.Separator = defaultIdentitySeparator
 	}
+	c.Delivery.check()
+	if c.Locality.Region == "" {
+		c.Locality.Region = os.Getenv(EnvRegion)
+	}
+	if c.Locality.Zone == "" {
+		c.Locality.Zone = os.Getenv(EnvZone)
+	}
+}
+
+func (c *DeliveryConfig) check() {
+	if c.BufferSize <= 0 {
+		c.BufferSize = defaultDeliveryBufferSize
+	}
+	// never block watch of database on slow subscribers by default
+	if c.Overflow == "" {
+		c.Overflow = OverflowCoalesce
+	}
 }
 
 func (c *Config) getStoragePrefix() string {
@@ -66,3 +107,15 @@ func (c *Config) getStoragePrefix() string {
 func (c *Config) getRegistryPrefix() string {
 	return fmt.Sprintf("%sregistry/", c.Prefix)
 }
+
+func (c *Config) getElectionPrefix() string {
+	return fmt.Sprintf("%selection/", c.Prefix)
+}
+
+func (c *Config) getLockPrefix() string {
+	return fmt.Sprintf("%slock/", c.Prefix)
+}
+
+func (c *Config) getLockTokenPrefix() string {
+	return fmt.Sprintf("%slock-token/", c.Prefix)
+}
@@ -2,8 +2,29 @@ package simple_registry
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+var (
+	ErrKeyNotFound  = errors.New("key not found")
+	ErrConflict     = errors.New("conflict")
+	ErrNotSupported = errors.New("not supported by database")
+)
+
+// compare target define
+const (
+	compareValue compareTarget = iota
+	compareRevision
+)
+
+// transaction operation define
+const (
+	opSet opType = iota
+	opDelete
 )
 
 type (
@@ -11,13 +32,19 @@ type (
 	WatchHandler func(ctx context.Context, e Event)
 	// KV kv
 	KV struct {
-		Key   string
-		Value *g.Var
+		Key            string
+		Value          *g.Var
+		CreateRevision int64 // revision of creation, 0 if not supported by database
+		ModRevision    int64 // revision of last modification, 0 if not supported by database
+		Version        int64 // times of modification since created, 0 if not supported by database
+		Lease          int64 // id of attached lease, 0 if not attached or not supported by database
 	}
 	// Event of database key value changes
 	Event struct {
 		KV
-		Type EventType
+		Type     EventType
+		Revision int64 // revision of change, 0 if not supported by database
+		More     bool  // more events of the same revision follow, e.g. keys changed by one transaction
 	}
 	// Database abstract key-value database ability
 	Database interface {
@@ -27,9 +54,105 @@ type (
 		GetPrefix(ctx context.Context, key string) (v []*KV, err error)
 		// Set value to database
 		Set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error)
+		// Update value of existing key and keep its lease, ErrKeyNotFound if key not exist
+		Update(ctx context.Context, key string, value interface{}) (err error)
 		// Delete value from database
 		Delete(ctx context.Context, key string) (err error)
+		// Revoke lease attached to key, keys attached to the lease are deleted,
+		// key without lease is deleted only
+		Revoke(ctx context.Context, key string) (err error)
+		// CompareAndSet value if compare matched and keep its lease, *ConflictError if not matched
+		CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error)
+		// Create value if key not exist, *ConflictError if exist
+		Create(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error)
+		// Txn commits ops atomically if all guards matched, *ConflictError of first not matched guard.
+		// revision of committed ops, 0 if not supported by database
+		Txn(ctx context.Context, guards []Guard, ops ...Op) (revision int64, err error)
 		// Watch database changes
 		Watch(ctx context.Context, key string, handler WatchHandler) (err error)
+		// Close connections to database
+		Close() (err error)
+	}
+	// Compare condition of CompareAndSet, created by CompareValue or CompareRevision
+	Compare struct {
+		target   compareTarget
+		value    string
+		revision int64
+	}
+	compareTarget int
+	// Guard of transaction matches if value of key matches compare
+	Guard struct {
+		Key     string
+		Compare Compare
+	}
+	// Op of transaction, created by OpSet or OpDelete
+	Op struct {
+		typ   opType
+		key   string
+		value string
+	}
+	opType int
+	// ConflictError returned if compare not matched, errors.Is(err, ErrConflict) is true
+	ConflictError struct {
+		Key     string
+		Current *KV // current value, nil if key not exist
 	}
 )
+
+// CompareValue matches if current value equals to value
+func CompareValue(value interface{}) Compare {
+	return Compare{target: compareValue, value: gconv.String(value)}
+}
+
+// CompareRevision matches if KV.ModRevision of current value equals to revision,
+// revision 0 matches key not exist
+func CompareRevision(revision int64) Compare {
+	return Compare{target: compareRevision, revision: revision}
+}
+
+// OpSet sets value of key without lease
+func OpSet(key string, value interface{}) Op {
+	return Op{typ: opSet, key: key, value: gconv.String(value)}
+}
+
+// OpDelete deletes key, or keys with prefix if key ends with "/"
+func OpDelete(key string) Op {
+	return Op{typ: opDelete, key: key}
+}
+
+// match current kv, nil if key not exist
+func (c Compare) match(kv *KV) bool {
+	if c.target == compareValue {
+		return kv != nil && kv.Value.String() == c.value
+	}
+	if kv == nil {
+		return c.revision == 0
+	}
+	return kv.ModRevision == c.revision
+}
+
+func (e *ConflictError) Error() string {
+	if e.Current == nil {
+		return fmt.Sprintf("conflict on %s: key not exist", e.Key)
+	}
+	return fmt.Sprintf("conflict on %s: current revision %d", e.Key, e.Current.ModRevision)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// atomicCAS reports whether compare and set of database is atomic across processes,
+// nacos emulates it in process only
+func atomicCAS(db Database) bool {
+	_, emulated := db.(*nacos)
+	return !emulated
+}
+
+// markMore events followed by ones of the same revision, events of a revision must be adjacent
+func markMore(es []Event) []Event {
+	for i := 0; i+1 < len(es); i++ {
+		es[i].More = es[i].Revision != 0 && es[i].Revision == es[i+1].Revision
+	}
+	return es
+}
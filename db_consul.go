@@ -0,0 +1,588 @@
+package simple_registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	// consulMinSessionTTL minimum session ttl accepted by consul
+	consulMinSessionTTL = 10
+	// consulWatchWait max wait time of blocking query
+	consulWatchWait = "30s"
+	// consulRetryInterval wait before retry failed blocking query
+	consulRetryInterval = time.Second
+)
+
+type (
+	// consul implements Database by consul http api,
+	// keys are stored without leading "/" and session with ttl works as lease.
+	consul struct {
+		cli       *http.Client
+		endpoints []string
+		token     string
+	}
+	consulKV struct {
+		Key         string `json:"Key"`
+		Value       []byte `json:"Value"`
+		CreateIndex uint64 `json:"CreateIndex"`
+		ModifyIndex uint64 `json:"ModifyIndex"`
+		Session     string `json:"Session"`
+	}
+	consulTxnOp struct {
+		KV consulTxnKV `json:"KV"`
+	}
+	consulTxnKV struct {
+		Verb    string `json:"Verb"`
+		Key     string `json:"Key"`
+		Value   []byte `json:"Value,omitempty"`
+		Index   uint64 `json:"Index,omitempty"`
+		Session string `json:"Session,omitempty"`
+	}
+	consulTxnResult struct {
+		Results []struct {
+			KV *consulKV `json:"KV"`
+		} `json:"Results"`
+		Errors []struct {
+			OpIndex int    `json:"OpIndex"`
+			What    string `json:"What"`
+		} `json:"Errors"`
+	}
+)
+
+func newConsul(_ context.Context, cfg DatabaseConfig) (c *consul, err error) {
+	if len(cfg.Endpoints) == 0 {
+		err = fmt.Errorf("consul endpoints not provided")
+		return
+	}
+	scheme := "http://"
+	if cfg.Tls != nil {
+		scheme = "https://"
+	}
+	endpoints := make([]string, 0, len(cfg.Endpoints))
+	for _, endpoint := range cfg.Endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = scheme + endpoint
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	c = &consul{
+		cli: &http.Client{Transport: &http.Transport{
+			DialContext:     (&net.Dialer{Timeout: time.Second * 10}).DialContext,
+			TLSClientConfig: cfg.tlsConfig(),
+		}},
+		endpoints: endpoints,
+		// consul authorize by acl token
+		token: cfg.Password,
+	}
+	return
+}
+
+func (c *consul) Get(ctx context.Context, key string) (v []*KV, err error) {
+	if strings.HasSuffix(key, "/") {
+		return c.GetPrefix(ctx, key)
+	}
+	kvs, _, err := c.list(ctx, key, nil)
+	if err != nil {
+		return
+	}
+	return c.toKVs(kvs), nil
+}
+
+func (c *consul) GetPrefix(ctx context.Context, key string) (v []*KV, err error) {
+	kvs, _, err := c.list(ctx, key, url.Values{"recurse": {"true"}})
+	if err != nil {
+		return
+	}
+	return c.toKVs(kvs), nil
+}
+
+func (c *consul) Set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	kvs, _, err := c.list(ctx, key, nil)
+	if err != nil {
+		return
+	}
+	var held, session string
+	if len(kvs) > 0 {
+		held = kvs[0].Session
+	}
+	// without ttl the key is detached from session holding it, as put without lease in etcd
+	if ttl > 0 {
+		if ttl < consulMinSessionTTL {
+			ttl = consulMinSessionTTL
+		}
+		if session, err = c.createSession(ctx, ttl); err != nil {
+			return
+		}
+	}
+	ok, err := c.acquire(ctx, key, value, session, held)
+	if err == nil && !ok {
+		err = fmt.Errorf("consul failed to set %s", key)
+	}
+	if err != nil {
+		if session != "" {
+			_ = c.destroySession(ctx, session)
+		}
+		return
+	}
+	// released by the swap, destroy won't delete the key
+	if held != "" {
+		if err := c.destroySession(ctx, held); err != nil {
+			g.Log().Warningf(ctx, "consul failed to destroy released session %s: %v", held, err)
+		}
+	}
+	if session != "" && len(keepalive) > 0 && keepalive[0] {
+		go c.keepalive(ctx, session, ttl)
+	}
+	return
+}
+
+// acquire key by session, or detach it from any session if session is empty.
+// key held by another session is released in the same transaction,
+// acquiring a key held by others fails and plain put keeps the holder in consul.
+func (c *consul) acquire(ctx context.Context, key string, value interface{}, session, held string) (ok bool, err error) {
+	switch {
+	case held == "" && session == "":
+		return c.put(ctx, key, value, nil)
+	case held == "" || held == session:
+		return c.put(ctx, key, value, url.Values{"acquire": {session}})
+	}
+	put := consulTxnKV{Verb: "lock", Key: consulKey(key), Value: []byte(gconv.String(value)), Session: session}
+	if session == "" {
+		put.Verb = "set"
+	}
+	payload, _ := json.Marshal([]consulTxnOp{
+		{KV: consulTxnKV{Verb: "unlock", Key: consulKey(key), Session: held}},
+		{KV: put},
+	})
+	resp, err := c.do(ctx, http.MethodPut, "/v1/txn", nil, payload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		ok = true
+	case http.StatusConflict:
+	default:
+		err = consulError(resp)
+	}
+	return
+}
+
+func (c *consul) Update(ctx context.Context, key string, value interface{}) (err error) {
+	kvs, _, err := c.list(ctx, key, nil)
+	if err != nil {
+		return
+	}
+	if len(kvs) == 0 {
+		return ErrKeyNotFound
+	}
+
+	// cas on modify index in case deleted or changed concurrently, acquire again by the same session
+	query := url.Values{"cas": {strconv.FormatUint(kvs[0].ModifyIndex, 10)}}
+	if kvs[0].Session != "" {
+		query.Set("acquire", kvs[0].Session)
+	}
+	body, err := c.request(ctx, http.MethodPut, "/v1/kv/"+consulKey(key), query, []byte(gconv.String(value)))
+	if err != nil {
+		return
+	}
+	if ok, _ := strconv.ParseBool(strings.TrimSpace(string(body))); !ok {
+		err = fmt.Errorf("consul failed to update %s", key)
+	}
+	return
+}
+
+func (c *consul) CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error) {
+	kvs, _, err := c.list(ctx, key, nil)
+	if err != nil {
+		return
+	}
+	// cas index 0 puts only if key not exist
+	var (
+		cur   *KV
+		query = url.Values{"cas": {"0"}}
+	)
+	if len(kvs) > 0 {
+		cur = c.toKV(kvs[0])
+		query.Set("cas", strconv.FormatUint(kvs[0].ModifyIndex, 10))
+		if kvs[0].Session != "" {
+			query.Set("acquire", kvs[0].Session)
+		}
+	}
+	if !cmp.match(cur) {
+		return &ConflictError{Key: key, Current: cur}
+	}
+	ok, err := c.put(ctx, key, value, query)
+	if err == nil && !ok {
+		err = c.conflict(ctx, key)
+	}
+	return
+}
+
+func (c *consul) Create(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	query := url.Values{"cas": {"0"}}
+	var session string
+	if ttl > 0 {
+		if ttl < consulMinSessionTTL {
+			ttl = consulMinSessionTTL
+		}
+		if session, err = c.createSession(ctx, ttl); err != nil {
+			return
+		}
+		query.Set("acquire", session)
+	}
+	ok, err := c.put(ctx, key, value, query)
+	switch {
+	case err == nil && ok:
+		if session != "" && len(keepalive) > 0 && keepalive[0] {
+			go c.keepalive(ctx, session, ttl)
+		}
+		return
+	case err == nil:
+		err = c.conflict(ctx, key)
+	}
+	if session != "" {
+		_ = c.destroySession(ctx, session)
+	}
+	return
+}
+
+// Txn by consul transaction api, value compare is checked by modify index read before
+func (c *consul) Txn(ctx context.Context, guards []Guard, ops ...Op) (revision int64, err error) {
+	txn := make([]consulTxnOp, 0, len(guards)+len(ops))
+	for _, guard := range guards {
+		op := consulTxnKV{Key: consulKey(guard.Key), Verb: "check-index", Index: uint64(guard.Compare.revision)}
+		if guard.Compare.target == compareValue {
+			var kvs []*consulKV
+			if kvs, _, err = c.list(ctx, guard.Key, nil); err != nil {
+				return
+			}
+			if len(kvs) == 0 || !guard.Compare.match(c.toKV(kvs[0])) {
+				return 0, c.conflict(ctx, guard.Key)
+			}
+			op.Index = kvs[0].ModifyIndex
+		}
+		if op.Index == 0 {
+			op.Verb = "check-not-exists"
+		}
+		txn = append(txn, consulTxnOp{KV: op})
+	}
+	for _, op := range ops {
+		kv := consulTxnKV{Key: consulKey(op.key), Verb: "set", Value: []byte(op.value)}
+		if op.typ == opDelete {
+			kv.Verb = "delete"
+			if strings.HasSuffix(op.key, "/") {
+				kv.Verb = "delete-tree"
+			}
+		}
+		txn = append(txn, consulTxnOp{KV: kv})
+	}
+
+	payload, _ := json.Marshal(txn)
+	resp, err := c.do(ctx, http.MethodPut, "/v1/txn", nil, payload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return 0, consulError(resp)
+	}
+	res := consulTxnResult{}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return
+	}
+	if resp.StatusCode == http.StatusConflict {
+		for _, e := range res.Errors {
+			if e.OpIndex < len(guards) {
+				return 0, c.conflict(ctx, guards[e.OpIndex].Key)
+			}
+		}
+		return 0, fmt.Errorf("consul transaction rolled back: %+v", res.Errors)
+	}
+	// all ops share one index
+	for _, r := range res.Results {
+		if r.KV != nil && int64(r.KV.ModifyIndex) > revision {
+			revision = int64(r.KV.ModifyIndex)
+		}
+	}
+	return
+}
+
+// put value and returns false if cas or acquire failed
+func (c *consul) put(ctx context.Context, key string, value interface{}, query url.Values) (ok bool, err error) {
+	body, err := c.request(ctx, http.MethodPut, "/v1/kv/"+consulKey(key), query, []byte(gconv.String(value)))
+	if err != nil {
+		return
+	}
+	ok, _ = strconv.ParseBool(strings.TrimSpace(string(body)))
+	return
+}
+
+// conflict error with current value
+func (c *consul) conflict(ctx context.Context, key string) error {
+	ce := &ConflictError{Key: key}
+	if kvs, _, err := c.list(ctx, key, nil); err == nil && len(kvs) > 0 {
+		ce.Current = c.toKV(kvs[0])
+	}
+	return ce
+}
+
+func (c *consul) createSession(ctx context.Context, ttl int64) (id string, err error) {
+	payload, _ := json.Marshal(map[string]string{
+		"TTL":       fmt.Sprintf("%ds", ttl),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	body, err := c.request(ctx, http.MethodPut, "/v1/session/create", nil, payload)
+	if err != nil {
+		return
+	}
+	res := struct {
+		ID string `json:"ID"`
+	}{}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return
+	}
+	id = res.ID
+	return
+}
+
+// destroySession even if ctx is done, keys acquired by it are deleted
+func (c *consul) destroySession(ctx context.Context, session string) (err error) {
+	_, err = c.request(context.WithoutCancel(ctx), http.MethodPut, "/v1/session/destroy/"+session, nil, nil)
+	return
+}
+
+func (c *consul) keepalive(ctx context.Context, session string, ttl int64) {
+	ticker := time.NewTicker(time.Duration(ttl) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ok, err := c.renew(ctx, session)
+			if err != nil {
+				g.Log().Warningf(ctx, "consul failed to renew session %s: %v", session, err)
+				continue
+			}
+			if !ok {
+				// destroyed or expired, nothing to keep
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// renew session, returns false if session not found
+func (c *consul) renew(ctx context.Context, session string) (ok bool, err error) {
+	resp, err := c.do(ctx, http.MethodPut, "/v1/session/renew/"+session, nil, nil)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		ok = true
+	case http.StatusNotFound:
+	default:
+		err = consulError(resp)
+	}
+	return
+}
+
+func (c *consul) Delete(ctx context.Context, key string) (err error) {
+	query := url.Values{}
+	if strings.HasSuffix(key, "/") {
+		query.Set("recurse", "true")
+	}
+	_, err = c.request(ctx, http.MethodDelete, "/v1/kv/"+consulKey(key), query, nil)
+	return
+}
+
+func (c *consul) Revoke(ctx context.Context, key string) (err error) {
+	kvs, _, err := c.list(ctx, key, nil)
+	if err != nil || len(kvs) == 0 {
+		return
+	}
+	// destroy session deletes keys acquired by it
+	if kvs[0].Session != "" {
+		if err = c.destroySession(ctx, kvs[0].Session); err != nil {
+			return
+		}
+	}
+	return c.Delete(ctx, key)
+}
+
+// Close idle connections, sessions are left to expire
+func (c *consul) Close() (err error) {
+	c.cli.CloseIdleConnections()
+	return
+}
+
+func (c *consul) Watch(ctx context.Context, key string, handler WatchHandler) (err error) {
+	query := url.Values{}
+	if strings.HasSuffix(key, "/") {
+		query.Set("recurse", "true")
+	}
+	// snapshot before watch, only changes after will be notified.
+	// watch from empty snapshot if failed, existing keys will be notified as created once reachable
+	kvs, index, lErr := c.list(ctx, key, query)
+	if lErr != nil {
+		g.Log().Warningf(ctx, "consul failed to list %s, watch from empty snapshot: %v", key, lErr)
+		kvs, index = nil, 0
+	}
+	go c.watch(ctx, key, query, index, kvs, handler)
+	return
+}
+
+func (c *consul) watch(ctx context.Context, key string, query url.Values, index uint64, kvs []*consulKV, handler WatchHandler) {
+	g.Log().Infof(ctx, "consul watching %s", key)
+	defer func() {
+		g.Log().Infof(ctx, "consul stop watching %s", key)
+	}()
+
+	snapshot := make(map[string]*consulKV)
+	for _, kv := range kvs {
+		snapshot[kv.Key] = kv
+	}
+	for {
+		q := url.Values{"index": {strconv.FormatUint(index, 10)}, "wait": {consulWatchWait}}
+		for k, v := range query {
+			q[k] = v
+		}
+		kvs, idx, err := c.list(ctx, key, q)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(consulRetryInterval):
+			}
+			g.Log().Warningf(ctx, "consul watch %s retry: %v", key, err)
+			continue
+		}
+		// index went backwards, reset blocking query
+		if idx < index {
+			idx = 0
+		}
+		index = idx
+
+		var es []Event
+		current := make(map[string]*consulKV)
+		for _, kv := range kvs {
+			current[kv.Key] = kv
+			ori, ok := snapshot[kv.Key]
+			switch {
+			case !ok:
+				es = append(es, Event{KV: *c.toKV(kv), Type: EventTypeCreate, Revision: int64(kv.ModifyIndex)})
+			case ori.ModifyIndex != kv.ModifyIndex:
+				es = append(es, Event{KV: *c.toKV(kv), Type: EventTypeUpdate, Revision: int64(kv.ModifyIndex)})
+			}
+		}
+		for k := range snapshot {
+			if _, ok := current[k]; !ok {
+				es = append(es, Event{KV: KV{Key: "/" + k, Value: g.NewVar("")}, Type: EventTypeDelete, Revision: int64(index)})
+			}
+		}
+		// in order of revision, events of a transaction share one index
+		sort.SliceStable(es, func(i, j int) bool { return es[i].Revision < es[j].Revision })
+		for _, e := range markMore(es) {
+			handler(ctx, e)
+		}
+		snapshot = current
+	}
+}
+
+// list kv by key, not found is not an error
+func (c *consul) list(ctx context.Context, key string, query url.Values) (kvs []*consulKV, index uint64, err error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v1/kv/"+consulKey(key), query, nil)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	index, _ = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return
+	case resp.StatusCode != http.StatusOK:
+		err = consulError(resp)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&kvs)
+	return
+}
+
+// request and read response body, non 200 status code returns as error
+func (c *consul) request(ctx context.Context, method, path string, query url.Values, payload []byte) (body []byte, err error) {
+	resp, err := c.do(ctx, method, path, query, payload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = consulError(resp)
+		return
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// do request to endpoints in order until one is reachable
+func (c *consul) do(ctx context.Context, method, path string, query url.Values, payload []byte) (resp *http.Response, err error) {
+	for _, endpoint := range c.endpoints {
+		u := endpoint + path
+		if len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload)); err != nil {
+			return
+		}
+		if c.token != "" {
+			req.Header.Set("X-Consul-Token", c.token)
+		}
+		if resp, err = c.cli.Do(req); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+func (c *consul) toKV(kv *consulKV) *KV {
+	return &KV{
+		Key:            "/" + kv.Key,
+		Value:          g.NewVar(kv.Value),
+		CreateRevision: int64(kv.CreateIndex),
+		ModRevision:    int64(kv.ModifyIndex),
+	}
+}
+
+func (c *consul) toKVs(kvs []*consulKV) (v []*KV) {
+	for _, kv := range kvs {
+		v = append(v, c.toKV(kv))
+	}
+	return
+}
+
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func consulError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("consul response %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
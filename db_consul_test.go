@@ -0,0 +1,475 @@
+package simple_registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConsul implements a subset of consul kv and session http api
+type fakeConsul struct {
+	mu       sync.Mutex
+	index    uint64
+	kvs      map[string]*consulKV
+	sessions map[string]int // session id : renew count
+	seq      int            // session id sequence
+	changed  chan struct{}
+}
+
+func newFakeConsul() (*fakeConsul, *httptest.Server) {
+	f := &fakeConsul{
+		index:    1,
+		kvs:      make(map[string]*consulKV),
+		sessions: make(map[string]int),
+		changed:  make(chan struct{}),
+	}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		f.mu.Lock()
+		f.seq++
+		id := "session-" + strconv.Itoa(f.seq)
+		f.sessions[id] = 0
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		f.mu.Lock()
+		_, ok := f.sessions[id]
+		if ok {
+			f.sessions[id]++
+		}
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		f.invalidate(strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		_, _ = w.Write([]byte("true"))
+	case r.URL.Path == "/v1/txn":
+		f.serveTxn(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		f.serveKV(w, r, strings.TrimPrefix(r.URL.Path, "/v1/kv/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	recurse := query.Has("recurse")
+	switch r.Method {
+	case http.MethodGet:
+		index, _ := strconv.ParseUint(query.Get("index"), 10, 64)
+		f.mu.Lock()
+		for index > 0 && f.index <= index {
+			changed := f.changed
+			f.mu.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			f.mu.Lock()
+			if f.changed == changed {
+				break
+			}
+		}
+		kvs := make([]*consulKV, 0)
+		for k, kv := range f.kvs {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				kvs = append(kvs, kv)
+			}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		f.mu.Unlock()
+		if len(kvs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+		_ = json.NewEncoder(w).Encode(kvs)
+	case http.MethodPut:
+		value, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		kv, ok := f.kvs[key]
+		if query.Has("cas") {
+			cas, _ := strconv.ParseUint(query.Get("cas"), 10, 64)
+			if (cas == 0 && ok) || (cas > 0 && (!ok || kv.ModifyIndex != cas)) {
+				f.mu.Unlock()
+				_, _ = w.Write([]byte("false"))
+				return
+			}
+		}
+		// acquire fails if held by another session
+		acquire := query.Get("acquire")
+		if acquire != "" && !f.acquirable(kv, acquire) {
+			f.mu.Unlock()
+			_, _ = w.Write([]byte("false"))
+			return
+		}
+		f.index++
+		if !ok {
+			kv = &consulKV{Key: key, CreateIndex: f.index}
+			f.kvs[key] = kv
+		}
+		kv.Value = value
+		kv.ModifyIndex = f.index
+		if acquire != "" {
+			kv.Session = acquire
+		}
+		f.notify()
+		f.mu.Unlock()
+		_, _ = w.Write([]byte("true"))
+	case http.MethodDelete:
+		f.mu.Lock()
+		f.index++
+		for k := range f.kvs {
+			if k == key || (recurse && strings.HasPrefix(k, key)) {
+				delete(f.kvs, k)
+			}
+		}
+		f.notify()
+		f.mu.Unlock()
+		_, _ = w.Write([]byte("true"))
+	}
+}
+
+func (f *fakeConsul) serveTxn(w http.ResponseWriter, r *http.Request) {
+	var ops []consulTxnOp
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// session holding keys after preceding ops
+	held := make(map[string]string)
+	for i, op := range ops {
+		kv, ok := f.kvs[op.KV.Key]
+		session, changed := held[op.KV.Key]
+		if !changed && ok {
+			session = kv.Session
+		}
+		var failed bool
+		switch op.KV.Verb {
+		case "check-not-exists":
+			failed = ok
+		case "check-index":
+			failed = !ok || kv.ModifyIndex != op.KV.Index
+		case "unlock":
+			failed, held[op.KV.Key] = session != op.KV.Session, ""
+		case "lock":
+			_, exist := f.sessions[op.KV.Session]
+			failed, held[op.KV.Key] = !exist || (session != "" && session != op.KV.Session), op.KV.Session
+		}
+		if failed {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"Errors": []map[string]interface{}{{"OpIndex": i, "What": "check failed"}},
+			})
+			return
+		}
+	}
+	f.index++
+	results := make([]map[string]interface{}, 0)
+	for _, op := range ops {
+		switch op.KV.Verb {
+		case "set":
+			kv, ok := f.kvs[op.KV.Key]
+			if !ok {
+				kv = &consulKV{Key: op.KV.Key, CreateIndex: f.index}
+				f.kvs[op.KV.Key] = kv
+			}
+			kv.Value, kv.ModifyIndex = op.KV.Value, f.index
+			results = append(results, map[string]interface{}{"KV": kv})
+		case "lock":
+			kv, ok := f.kvs[op.KV.Key]
+			if !ok {
+				kv = &consulKV{Key: op.KV.Key, CreateIndex: f.index}
+				f.kvs[op.KV.Key] = kv
+			}
+			kv.Value, kv.ModifyIndex, kv.Session = op.KV.Value, f.index, op.KV.Session
+			results = append(results, map[string]interface{}{"KV": kv})
+		case "unlock":
+			if kv, ok := f.kvs[op.KV.Key]; ok {
+				kv.ModifyIndex, kv.Session = f.index, ""
+			}
+		case "delete":
+			delete(f.kvs, op.KV.Key)
+		case "delete-tree":
+			for k := range f.kvs {
+				if strings.HasPrefix(k, op.KV.Key) {
+					delete(f.kvs, k)
+				}
+			}
+		}
+	}
+	f.notify()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"Results": results})
+}
+
+// acquirable if session exists and key not held by others, caller must hold lock
+func (f *fakeConsul) acquirable(kv *consulKV, session string) bool {
+	if _, ok := f.sessions[session]; !ok {
+		return false
+	}
+	return kv == nil || kv.Session == "" || kv.Session == session
+}
+
+// invalidate session and delete acquired keys
+func (f *fakeConsul) invalidate(session string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	delete(f.sessions, session)
+	for k, kv := range f.kvs {
+		if kv.Session == session {
+			delete(f.kvs, k)
+		}
+	}
+	f.notify()
+}
+
+// notify blocking queries, caller must hold lock
+func (f *fakeConsul) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func TestConsul(t *testing.T) {
+	fake, server := newFakeConsul()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := newConsul(ctx, DatabaseConfig{Endpoints: []string{strings.TrimPrefix(server.URL, "http://")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 10)
+	if err = db.Watch(ctx, "/test/", func(_ context.Context, e Event) {
+		events <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var expect = func(typ EventType, key string) {
+		select {
+		case e := <-events:
+			if e.Type != typ || e.Key != key {
+				t.Fatalf("unexpected event: %s %s", e.Type, e.Key)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("wait %s %s event timeout", typ, key)
+		}
+	}
+
+	if err = db.Set(ctx, "/test/a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, "/test/a")
+	if err = db.Set(ctx, "/test/a", "2", 0); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, "/test/a")
+	if err = db.Set(ctx, "/test/b", "3", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, "/test/b")
+	if err = db.Update(ctx, "/test/b", "4"); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, "/test/b")
+	// set again swaps the session holding the key
+	fake.mu.Lock()
+	held := fake.kvs["test/b"].Session
+	fake.mu.Unlock()
+	if err = db.Set(ctx, "/test/b", "4", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, "/test/b")
+	fake.mu.Lock()
+	_, alive := fake.sessions[held]
+	sessions := len(fake.sessions)
+	fake.mu.Unlock()
+	if alive || sessions != 1 {
+		t.Fatalf("released session %s not destroyed, %d sessions alive", held, sessions)
+	}
+	if err = db.Update(ctx, "/test/c", "4"); err != ErrKeyNotFound {
+		t.Fatalf("expect key not found, got %v", err)
+	}
+
+	kvs, err := db.Get(ctx, "/test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value.String() != "2" {
+		t.Fatalf("get not match: %+v", kvs)
+	}
+	kvs, err = db.Get(ctx, "/test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 {
+		t.Fatalf("get prefix not match: %+v", kvs)
+	}
+	if kvs, err = db.Get(ctx, "/not-exist"); err != nil || len(kvs) != 0 {
+		t.Fatalf("get not exist: %v %+v", err, kvs)
+	}
+
+	// invalidated session deletes acquired value
+	fake.mu.Lock()
+	session := fake.kvs["test/b"].Session
+	fake.mu.Unlock()
+	if session == "" {
+		t.Fatal("ttl value not acquired by session")
+	}
+	fake.invalidate(session)
+	expect(EventTypeDelete, "/test/b")
+
+	// set without ttl detaches key from session, plain put keeps the holder in consul
+	if err = db.Set(ctx, "/plain/d", "1", 1); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	session = fake.kvs["plain/d"].Session
+	fake.mu.Unlock()
+	if err = db.Set(ctx, "/plain/d", "2", 0); err != nil {
+		t.Fatal(err)
+	}
+	fake.invalidate(session)
+	if kvs, err = db.Get(ctx, "/plain/d"); err != nil || len(kvs) != 1 || kvs[0].Value.String() != "2" {
+		t.Fatalf("value without ttl deleted with session: %v %+v", err, kvs)
+	}
+
+	// compare and set by modify index
+	kvs, _ = db.Get(ctx, "/test/a")
+	if err = db.CompareAndSet(ctx, "/test/a", CompareRevision(kvs[0].ModRevision+1), "5"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err = db.CompareAndSet(ctx, "/test/a", CompareRevision(kvs[0].ModRevision), "5"); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, "/test/a")
+	if err = db.CompareAndSet(ctx, "/test/a", CompareValue("5"), "6"); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, "/test/a")
+	var ce *ConflictError
+	if err = db.Create(ctx, "/test/a", "7", 0); !errors.As(err, &ce) || ce.Current.Value.String() != "6" {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err = db.Create(ctx, "/test/c", "7", 10); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, "/test/c")
+
+	if err = db.Delete(ctx, "/test/"); err != nil {
+		t.Fatal(err)
+	}
+	deleted := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if e.Type == EventTypeDelete {
+				deleted[e.Key] = true
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatal("wait delete event timeout")
+		}
+	}
+	if !deleted["/test/a"] || !deleted["/test/c"] {
+		t.Fatalf("unexpected delete events: %v", deleted)
+	}
+}
+
+func TestConsul_Txn(t *testing.T) {
+	_, server := newFakeConsul()
+	defer server.Close()
+	ctx := context.Background()
+	db, err := newConsul(ctx, DatabaseConfig{Endpoints: []string{strings.TrimPrefix(server.URL, "http://")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := db.Txn(ctx, []Guard{{Key: "/test/a", Compare: CompareRevision(0)}}, OpSet("/test/a", "1"), OpSet("/test/b", "2"))
+	if err != nil || rev == 0 {
+		t.Fatalf("txn failed: %d %v", rev, err)
+	}
+	kvs, _ := db.Get(ctx, "/test/")
+	if len(kvs) != 2 || kvs[0].ModRevision != rev || kvs[1].ModRevision != rev {
+		t.Fatalf("unexpected kvs: %+v", kvs)
+	}
+
+	var ce *ConflictError
+	for _, guards := range [][]Guard{
+		{{Key: "/test/a", Compare: CompareValue("1")}, {Key: "/test/b", Compare: CompareRevision(rev + 1)}},
+		{{Key: "/test/a", Compare: CompareRevision(rev)}, {Key: "/test/b", Compare: CompareValue("1")}},
+	} {
+		if _, err = db.Txn(ctx, guards, OpDelete("/test/a")); !errors.As(err, &ce) || ce.Key != "/test/b" {
+			t.Fatalf("expect conflict of /test/b, got %v", err)
+		}
+	}
+	if _, err = db.Txn(ctx, []Guard{{Key: "/test/b", Compare: CompareValue("2")}}, OpDelete("/test/")); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ = db.Get(ctx, "/test/"); len(kvs) != 0 {
+		t.Fatalf("unexpected kvs after delete: %+v", kvs)
+	}
+}
+
+func TestConsul_WatchUnavailable(t *testing.T) {
+	fake, _ := newFakeConsul()
+	var down atomic.Bool
+	down.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := newConsul(ctx, DatabaseConfig{Endpoints: []string{strings.TrimPrefix(server.URL, "http://")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 10)
+	if err = db.Watch(ctx, "/test/", func(_ context.Context, e Event) {
+		events <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// existing keys notified once reachable
+	down.Store(false)
+	if err = db.Set(ctx, "/test/a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventTypeCreate || e.Key != "/test/a" {
+			t.Fatalf("unexpected event: %s %s", e.Type, e.Key)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait event timeout")
+	}
+}
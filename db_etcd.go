@@ -2,14 +2,22 @@ package simple_registry
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
 	"github.com/gogf/gf/v2/util/gconv"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
+const (
+	// etcdWatchRetryInterval wait before resume watching
+	etcdWatchRetryInterval = time.Second
+)
+
 type etcd struct {
 	cli *clientv3.Client
 }
@@ -38,10 +46,7 @@ func (e *etcd) Get(ctx context.Context, key string) (v []*KV, err error) {
 	}
 
 	for _, kv := range resp.Kvs {
-		v = append(v, &KV{
-			Key:   string(kv.Key),
-			Value: g.NewVar(kv.Value),
-		})
+		v = append(v, etcdKV(kv))
 	}
 	return
 }
@@ -53,10 +58,7 @@ func (e *etcd) GetPrefix(ctx context.Context, key string) (v []*KV, err error) {
 	}
 
 	for _, kv := range resp.Kvs {
-		v = append(v, &KV{
-			Key:   string(kv.Key),
-			Value: g.NewVar(kv.Value),
-		})
+		v = append(v, etcdKV(kv))
 	}
 	return
 }
@@ -81,6 +83,126 @@ func (e *etcd) Set(ctx context.Context, key string, value interface{}, ttl int64
 	return
 }
 
+func (e *etcd) Update(ctx context.Context, key string, value interface{}) (err error) {
+	_, err = e.cli.Put(ctx, key, gconv.String(value), clientv3.WithIgnoreLease())
+	if errors.Is(err, rpctypes.ErrKeyNotFound) {
+		err = ErrKeyNotFound
+	}
+	return
+}
+
+func (e *etcd) CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error) {
+	cond := e.compare(key, cmp)
+	// keep lease of existing key
+	var opts []clientv3.OpOption
+	if cmp.target == compareValue || cmp.revision > 0 {
+		opts = append(opts, clientv3.WithIgnoreLease())
+	}
+	resp, err := e.cli.Txn(ctx).
+		If(cond).
+		Then(clientv3.OpPut(key, gconv.String(value), opts...)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return
+	}
+	if !resp.Succeeded {
+		err = e.conflict(key, resp)
+	}
+	return
+}
+
+func (e *etcd) Create(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	var (
+		opts  []clientv3.OpOption
+		lease clientv3.Lease
+		grant *clientv3.LeaseGrantResponse
+	)
+	if ttl > 0 {
+		lease = clientv3.NewLease(e.cli)
+		if grant, err = lease.Grant(ctx, ttl); err != nil {
+			return
+		}
+		opts = append(opts, clientv3.WithLease(grant.ID))
+	}
+	resp, err := e.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, gconv.String(value), opts...)).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	switch {
+	case err == nil && resp.Succeeded:
+		if grant != nil && len(keepalive) > 0 && keepalive[0] {
+			go e.keepalive(ctx, lease, grant.ID)
+		}
+		return
+	case err == nil:
+		err = e.conflict(key, resp)
+	}
+	if grant != nil {
+		_, _ = lease.Revoke(context.WithoutCancel(ctx), grant.ID)
+	}
+	return
+}
+
+func (e *etcd) Txn(ctx context.Context, guards []Guard, ops ...Op) (revision int64, err error) {
+	conds := make([]clientv3.Cmp, 0, len(guards))
+	gets := make([]clientv3.Op, 0, len(guards))
+	for _, guard := range guards {
+		conds = append(conds, e.compare(guard.Key, guard.Compare))
+		gets = append(gets, clientv3.OpGet(guard.Key))
+	}
+	then := make([]clientv3.Op, 0, len(ops))
+	for _, op := range ops {
+		switch op.typ {
+		case opSet:
+			then = append(then, clientv3.OpPut(op.key, op.value))
+		case opDelete:
+			var opts []clientv3.OpOption
+			if strings.HasSuffix(op.key, "/") {
+				opts = append(opts, clientv3.WithPrefix())
+			}
+			then = append(then, clientv3.OpDelete(op.key, opts...))
+		}
+	}
+	resp, err := e.cli.Txn(ctx).If(conds...).Then(then...).Else(gets...).Commit()
+	if err != nil {
+		return
+	}
+	if resp.Succeeded {
+		return resp.Header.Revision, nil
+	}
+	// find the first guard not matched
+	for i, guard := range guards {
+		var cur *KV
+		if kvs := resp.Responses[i].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			cur = etcdKV(kvs[0])
+		}
+		if !guard.Compare.match(cur) {
+			return 0, &ConflictError{Key: guard.Key, Current: cur}
+		}
+	}
+	return 0, &ConflictError{Key: guards[0].Key}
+}
+
+func (e *etcd) compare(key string, cmp Compare) clientv3.Cmp {
+	if cmp.target == compareValue {
+		return clientv3.Compare(clientv3.Value(key), "=", cmp.value)
+	}
+	return clientv3.Compare(clientv3.ModRevision(key), "=", cmp.revision)
+}
+
+// conflict error with current value from else branch of txn
+func (e *etcd) conflict(key string, resp *clientv3.TxnResponse) error {
+	ce := &ConflictError{Key: key}
+	if len(resp.Responses) > 0 {
+		if kvs := resp.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			ce.Current = etcdKV(kvs[0])
+		}
+	}
+	return ce
+}
+
 func (e *etcd) keepalive(ctx context.Context, lease clientv3.Lease, id clientv3.LeaseID) {
 	resCh, err := lease.KeepAlive(ctx, id)
 	if err != nil {
@@ -88,9 +210,16 @@ func (e *etcd) keepalive(ctx context.Context, lease clientv3.Lease, id clientv3.
 	}
 	for {
 		select {
-		case _ = <-resCh:
+		case _, ok := <-resCh:
 			// discard keepalive message
 			// g.Log().Infof(ctx, "etcd keepalive %v", resp)
+			if !ok {
+				// lease expired or revoked, owner detects it by key deletion
+				if ctx.Err() == nil {
+					g.Log().Warningf(ctx, "etcd keepalive of lease %x closed", int64(id))
+				}
+				return
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -106,47 +235,125 @@ func (e *etcd) Delete(ctx context.Context, key string) (err error) {
 	return
 }
 
-func (e *etcd) watch(ctx context.Context, key string, handler WatchHandler) {
-	opts := make([]clientv3.OpOption, 0)
+func (e *etcd) Revoke(ctx context.Context, key string) (err error) {
+	resp, err := e.cli.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	for _, kv := range resp.Kvs {
+		if kv.Lease == 0 {
+			continue
+		}
+		if _, err = e.cli.Revoke(ctx, clientv3.LeaseID(kv.Lease)); err != nil && !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return
+		}
+	}
+	return e.Delete(ctx, key)
+}
+
+// watch key from revision, resume from last seen revision after error,
+// push EventTypeResync if revision compacted
+func (e *etcd) watch(ctx context.Context, key string, rev int64, handler WatchHandler) {
+	opts := []clientv3.OpOption{clientv3.WithProgressNotify()}
 	if strings.HasSuffix(key, "/") {
 		opts = append(opts, clientv3.WithPrefix())
 	}
-	g.Log().Infof(ctx, "etcd watching %s", key)
+	g.Log().Infof(ctx, "etcd watching %s from revision %d", key, rev)
 	defer func() {
 		g.Log().Infof(ctx, "etcd stop watching %s", key)
 	}()
 	for {
+		wopts := opts
+		if rev > 0 {
+			wopts = append(wopts, clientv3.WithRev(rev))
+		}
+		// cancel watch if lost leader, otherwise partitioned member may block forever
+		wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		rev = e.consume(ctx, key, e.cli.Watch(wctx, key, wopts...), rev, handler)
+		cancel()
+
 		select {
-		case resp := <-e.cli.Watch(ctx, key, opts...):
-			if resp.Canceled {
-				return
-			}
-			for _, ev := range resp.Events {
-				var typ EventType
-				if ev.IsModify() {
-					typ = EventTypeUpdate
-				}
-				if ev.IsCreate() {
-					typ = EventTypeCreate
-				}
-				if ev.Type == clientv3.EventTypeDelete {
-					typ = EventTypeDelete
-				}
-				handler(ctx, Event{
-					KV: KV{
-						Key:   string(ev.Kv.Key),
-						Value: g.NewVar(ev.Kv.Value),
-					},
-					Type: typ,
-				})
-			}
 		case <-ctx.Done():
 			return
+		case <-time.After(etcdWatchRetryInterval):
+		}
+		g.Log().Warningf(ctx, "etcd resume watching %s from revision %d", key, rev)
+	}
+}
+
+// consume watch responses until channel closed or error, returns next revision to watch
+func (e *etcd) consume(ctx context.Context, key string, ch clientv3.WatchChan, rev int64, handler WatchHandler) int64 {
+	for resp := range ch {
+		if resp.CompactRevision > 0 {
+			// resume after revision read by resync, replaying history since compaction
+			// on the reloaded cache brings back deleted values
+			cur, err := e.cli.Get(ctx, key, clientv3.WithCountOnly())
+			if err != nil {
+				g.Log().Warningf(ctx, "etcd watch %s revision %d compacted, failed to get revision: %v", key, rev, err)
+				return rev
+			}
+			g.Log().Warningf(ctx, "etcd watch %s revision %d compacted, resync at %d", key, rev, cur.Header.Revision)
+			handler(ctx, Event{KV: KV{Key: key, Value: g.NewVar(nil)}, Type: EventTypeResync})
+			return cur.Header.Revision + 1
+		}
+		if err := resp.Err(); err != nil {
+			g.Log().Warningf(ctx, "etcd watch %s error: %v", key, err)
+			return rev
+		}
+		// events of a revision are delivered in one response
+		es := make([]Event, 0, len(resp.Events))
+		for _, ev := range resp.Events {
+			var typ EventType
+			if ev.IsModify() {
+				typ = EventTypeUpdate
+			}
+			if ev.IsCreate() {
+				typ = EventTypeCreate
+			}
+			if ev.Type == clientv3.EventTypeDelete {
+				typ = EventTypeDelete
+			}
+			es = append(es, Event{
+				KV:       *etcdKV(ev.Kv),
+				Type:     typ,
+				Revision: ev.Kv.ModRevision,
+			})
+			rev = ev.Kv.ModRevision + 1
+		}
+		for _, e := range markMore(es) {
+			handler(ctx, e)
+		}
+		// nothing changed until header revision
+		if resp.IsProgressNotify() && resp.Header.Revision >= rev {
+			rev = resp.Header.Revision + 1
 		}
 	}
+	return rev
+}
+
+func (e *etcd) Close() (err error) {
+	return e.cli.Close()
 }
 
 func (e *etcd) Watch(ctx context.Context, key string, handler WatchHandler) (err error) {
-	go e.watch(ctx, key, handler)
+	// watch from current revision, so that changes won't be lost before watch created
+	var rev int64
+	if resp, gErr := e.cli.Get(ctx, key, clientv3.WithCountOnly()); gErr == nil {
+		rev = resp.Header.Revision + 1
+	} else {
+		g.Log().Warningf(ctx, "etcd failed to get revision of %s, watch from now: %v", key, gErr)
+	}
+	go e.watch(ctx, key, rev, handler)
 	return
 }
+
+func etcdKV(kv *mvccpb.KeyValue) *KV {
+	return &KV{
+		Key:            string(kv.Key),
+		Value:          g.NewVar(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+}
@@ -0,0 +1,429 @@
+package simple_registry
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+var (
+	// memoryStores shared by endpoints, memory databases created with
+	// the same endpoints behave like clients of the same cluster
+	memoryStores   = make(map[string]*memoryStore)
+	memoryStoresMu sync.Mutex
+)
+
+type (
+	// memory in process database, useful for tests and single process usage
+	memory struct {
+		store *memoryStore
+	}
+	memoryStore struct {
+		mu       sync.Mutex
+		kvs      map[string]*memoryValue
+		leases   map[int64]*memoryLease
+		leaseId  int64
+		revision int64
+		watchers map[*memoryWatcher]struct{}
+	}
+	memoryValue struct {
+		value          string
+		lease          int64
+		createRevision int64
+		revision       int64 // revision of last modification
+		version        int64
+	}
+	memoryLease struct {
+		id       int64
+		ttl      time.Duration
+		deadline time.Time
+		keys     map[string]struct{}
+		timer    *time.Timer
+	}
+	memoryWatcher struct {
+		key    string
+		prefix bool
+		mu     sync.Mutex
+		queue  []Event
+		notify chan struct{}
+	}
+)
+
+func newMemory(_ context.Context, cfg DatabaseConfig) (m *memory, err error) {
+	name := strings.Join(cfg.Endpoints, ",")
+	memoryStoresMu.Lock()
+	defer memoryStoresMu.Unlock()
+	store, ok := memoryStores[name]
+	if !ok {
+		store = &memoryStore{
+			kvs:      make(map[string]*memoryValue),
+			leases:   make(map[int64]*memoryLease),
+			watchers: make(map[*memoryWatcher]struct{}),
+		}
+		memoryStores[name] = store
+	}
+	m = &memory{store: store}
+	return
+}
+
+func (m *memory) Get(ctx context.Context, key string) (v []*KV, err error) {
+	if strings.HasSuffix(key, "/") {
+		return m.GetPrefix(ctx, key)
+	}
+
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kv := s.kv(key); kv != nil {
+		v = append(v, kv)
+	}
+	return
+}
+
+func (m *memory) GetPrefix(_ context.Context, key string) (v []*KV, err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys(key) {
+		v = append(v, s.kv(k))
+	}
+	return
+}
+
+func (m *memory) Set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.set(ctx, key, value, ttl, keepalive...)
+	return
+}
+
+// set value with new lease if ttl > 0, caller must hold lock
+func (m *memory) set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) {
+	s := m.store
+	var lease int64
+	if ttl > 0 {
+		l := s.grant(time.Duration(ttl) * time.Second)
+		l.keys[key] = struct{}{}
+		lease = l.id
+		if len(keepalive) > 0 && keepalive[0] {
+			go m.keepalive(ctx, l.id)
+		}
+	}
+	s.put(key, gconv.String(value), lease, s.next())
+}
+
+func (m *memory) Update(_ context.Context, key string, value interface{}) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ori, ok := s.kvs[key]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	s.put(key, gconv.String(value), ori.lease, s.next())
+	return
+}
+
+func (m *memory) CompareAndSet(_ context.Context, key string, cmp Compare, value interface{}) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.kv(key)
+	if !cmp.match(cur) {
+		return &ConflictError{Key: key, Current: cur}
+	}
+	var lease int64
+	if ori, ok := s.kvs[key]; ok {
+		lease = ori.lease
+	}
+	s.put(key, gconv.String(value), lease, s.next())
+	return
+}
+
+func (m *memory) Create(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur := s.kv(key); cur != nil {
+		return &ConflictError{Key: key, Current: cur}
+	}
+	m.set(ctx, key, value, ttl, keepalive...)
+	return
+}
+
+func (m *memory) Txn(_ context.Context, guards []Guard, ops ...Op) (revision int64, err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, guard := range guards {
+		if cur := s.kv(guard.Key); !guard.Compare.match(cur) {
+			return 0, &ConflictError{Key: guard.Key, Current: cur}
+		}
+	}
+	// all ops share one revision
+	revision = s.next()
+	for _, op := range ops {
+		switch {
+		case op.typ == opSet:
+			s.put(op.key, op.value, 0, revision)
+		case strings.HasSuffix(op.key, "/"):
+			for _, k := range s.keys(op.key) {
+				s.delete(k, revision)
+			}
+		default:
+			s.delete(op.key, revision)
+		}
+	}
+	return
+}
+
+func (m *memory) keepalive(ctx context.Context, id int64) {
+	s := m.store
+	s.mu.Lock()
+	l, ok := s.leases[id]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	interval := l.ttl / 3
+	if interval <= 0 {
+		interval = l.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			l, ok = s.leases[id]
+			if ok {
+				l.deadline = time.Now().Add(l.ttl)
+			}
+			s.mu.Unlock()
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *memory) Delete(_ context.Context, key string) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.HasSuffix(key, "/") {
+		for _, k := range s.keys(key) {
+			s.delete(k, s.next())
+		}
+		return
+	}
+	s.delete(key, s.next())
+	return
+}
+
+func (m *memory) Revoke(_ context.Context, key string) (err error) {
+	s := m.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kvs[key]
+	if !ok {
+		return
+	}
+	if l, has := s.leases[v.lease]; has {
+		l.timer.Stop()
+		delete(s.leases, l.id)
+		for k := range l.keys {
+			if kv, exist := s.kvs[k]; exist && kv.lease == l.id {
+				s.delete(k, s.next())
+			}
+		}
+	}
+	s.delete(key, s.next())
+	return
+}
+
+// Close nothing, values are kept in process
+func (m *memory) Close() (err error) {
+	return
+}
+
+func (m *memory) Watch(ctx context.Context, key string, handler WatchHandler) (err error) {
+	w := &memoryWatcher{
+		key:    key,
+		prefix: strings.HasSuffix(key, "/"),
+		notify: make(chan struct{}, 1),
+	}
+	s := m.store
+	s.mu.Lock()
+	s.watchers[w] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		g.Log().Infof(ctx, "memory watching %s", key)
+		defer func() {
+			s.mu.Lock()
+			delete(s.watchers, w)
+			s.mu.Unlock()
+			g.Log().Infof(ctx, "memory stop watching %s", key)
+		}()
+		for {
+			select {
+			case <-w.notify:
+				// drain with store locked, so that events of a transaction are drained together
+				s.mu.Lock()
+				es := w.drain()
+				s.mu.Unlock()
+				for _, e := range markMore(es) {
+					handler(ctx, e)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return
+}
+
+// keys matched prefix in order, caller must hold lock
+func (s *memoryStore) keys(prefix string) []string {
+	keys := make([]string, 0)
+	for k := range s.kvs {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// kv of key, nil if not exist, caller must hold lock
+func (s *memoryStore) kv(key string) *KV {
+	v, ok := s.kvs[key]
+	if !ok {
+		return nil
+	}
+	return &KV{
+		Key:            key,
+		Value:          g.NewVar(v.value),
+		CreateRevision: v.createRevision,
+		ModRevision:    v.revision,
+		Version:        v.version,
+		Lease:          v.lease,
+	}
+}
+
+// grant a lease expires after ttl, caller must hold lock
+func (s *memoryStore) grant(ttl time.Duration) *memoryLease {
+	s.leaseId++
+	l := &memoryLease{
+		id:       s.leaseId,
+		ttl:      ttl,
+		deadline: time.Now().Add(ttl),
+		keys:     make(map[string]struct{}),
+	}
+	l.timer = time.AfterFunc(ttl, func() { s.expire(l.id) })
+	s.leases[l.id] = l
+	return l
+}
+
+// expire lease and delete attached keys if deadline reached, otherwise check again later
+func (s *memoryStore) expire(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	if !ok {
+		return
+	}
+	if remain := time.Until(l.deadline); remain > 0 {
+		l.timer.Reset(remain)
+		return
+	}
+	delete(s.leases, id)
+	for k := range l.keys {
+		if v, has := s.kvs[k]; has && v.lease == id {
+			s.delete(k, s.next())
+		}
+	}
+}
+
+// next revision, caller must hold lock
+func (s *memoryStore) next() int64 {
+	s.revision++
+	return s.revision
+}
+
+// put value at revision and notify watchers, caller must hold lock
+func (s *memoryStore) put(key, value string, lease, rev int64) {
+	typ := EventTypeCreate
+	v := &memoryValue{value: value, lease: lease, createRevision: rev, revision: rev, version: 1}
+	if ori, ok := s.kvs[key]; ok {
+		typ = EventTypeUpdate
+		s.detach(key, ori.lease, lease)
+		v.createRevision, v.version = ori.createRevision, ori.version+1
+	}
+	s.kvs[key] = v
+	s.notify(Event{KV: *s.kv(key), Type: typ, Revision: rev})
+}
+
+// delete value at revision and notify watchers, caller must hold lock
+func (s *memoryStore) delete(key string, rev int64) {
+	ori, ok := s.kvs[key]
+	if !ok {
+		return
+	}
+	s.detach(key, ori.lease, 0)
+	delete(s.kvs, key)
+	s.notify(Event{KV: KV{Key: key, Value: g.NewVar(""), ModRevision: rev}, Type: EventTypeDelete, Revision: rev})
+}
+
+// detach key from previous lease, caller must hold lock
+func (s *memoryStore) detach(key string, from, to int64) {
+	if from == 0 || from == to {
+		return
+	}
+	if l, ok := s.leases[from]; ok {
+		delete(l.keys, key)
+	}
+}
+
+// notify matched watchers, caller must hold lock
+func (s *memoryStore) notify(e Event) {
+	for w := range s.watchers {
+		if w.match(e.Key) {
+			w.push(e)
+		}
+	}
+}
+
+func (w *memoryWatcher) match(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+func (w *memoryWatcher) push(e Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, e)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memoryWatcher) drain() (es []Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	es, w.queue = w.queue, nil
+	return
+}
@@ -0,0 +1,213 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory_GetSetDelete(t *testing.T) {
+	db, err := newMemory(context.Background(), DatabaseConfig{Endpoints: []string{t.Name()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for k, v := range map[string]string{"/a/1": "1", "/a/2": "2", "/b/1": "3"} {
+		if err = db.Set(ctx, k, v, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kvs, err := db.Get(ctx, "/a/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Value.String() != "1" {
+		t.Fatalf("get not match: %+v", kvs)
+	}
+	kvs, err = db.Get(ctx, "/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 2 || kvs[0].Key != "/a/1" || kvs[1].Key != "/a/2" {
+		t.Fatalf("get prefix not match: %+v", kvs)
+	}
+
+	if err = db.Update(ctx, "/not-exist", "v"); err != ErrKeyNotFound {
+		t.Fatalf("expect key not found, got %v", err)
+	}
+	if err = db.Update(ctx, "/a/1", "11"); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ = db.Get(ctx, "/a/1"); len(kvs) != 1 || kvs[0].Value.String() != "11" {
+		t.Fatalf("update not match: %+v", kvs)
+	}
+
+	// same endpoints share data
+	other, _ := newMemory(ctx, DatabaseConfig{Endpoints: []string{t.Name()}})
+	if err = other.Delete(ctx, "/a/"); err != nil {
+		t.Fatal(err)
+	}
+	kvs, err = db.GetPrefix(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Key != "/b/1" {
+		t.Fatalf("delete prefix not match: %+v", kvs)
+	}
+}
+
+func TestMemory_TTL(t *testing.T) {
+	db, _ := newMemory(context.Background(), DatabaseConfig{Endpoints: []string{t.Name()}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := db.Set(ctx, "ttl", "v", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set(ctx, "keepalive", "v", 1, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 2500)
+
+	if kvs, _ := db.Get(ctx, "ttl"); len(kvs) != 0 {
+		t.Fatal("ttl not work")
+	}
+	if kvs, _ := db.Get(ctx, "keepalive"); len(kvs) != 1 {
+		t.Fatal("keepalive not work")
+	}
+	// update keeps lease
+	if err := db.Update(ctx, "keepalive", "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// stop keepalive
+	cancel()
+	time.Sleep(time.Millisecond * 2500)
+	if kvs, _ := db.Get(context.Background(), "keepalive"); len(kvs) != 0 {
+		t.Fatal("lease not expired after keepalive stopped")
+	}
+}
+
+func TestMemory_Watch(t *testing.T) {
+	db, _ := newMemory(context.Background(), DatabaseConfig{Endpoints: []string{t.Name()}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan Event, 10)
+	if err := db.Watch(ctx, "/w/", func(_ context.Context, e Event) {
+		events <- e
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = db.Set(ctx, "/w/1", "a", 0)
+	_ = db.Set(ctx, "/w/1", "b", 0)
+	_ = db.Set(ctx, "/other", "c", 0)
+	_ = db.Delete(ctx, "/w/1")
+
+	expected := []EventType{EventTypeCreate, EventTypeUpdate, EventTypeDelete}
+	for _, typ := range expected {
+		select {
+		case e := <-events:
+			if e.Type != typ || e.Key != "/w/1" {
+				t.Fatalf("unexpected event: %s %s", e.Type, e.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait %s event timeout", typ)
+		}
+	}
+
+	// events of txn marked except the last one
+	_, _ = db.Txn(ctx, nil, OpSet("/w/1", "a"), OpSet("/w/2", "b"))
+	for _, more := range []bool{true, false} {
+		select {
+		case e := <-events:
+			if e.More != more {
+				t.Fatalf("unexpected more of %s: %v", e.Key, e.More)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait txn event timeout")
+		}
+	}
+}
+
+func TestMemory_CompareAndSet(t *testing.T) {
+	db, err := newMemory(context.Background(), DatabaseConfig{Endpoints: []string{t.Name()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err = db.Create(ctx, "/a", "1", 1); err != nil {
+		t.Fatal(err)
+	}
+	var ce *ConflictError
+	if err = db.Create(ctx, "/a", "2", 0); !errors.As(err, &ce) || ce.Current.Value.String() != "1" {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+
+	kvs, _ := db.Get(ctx, "/a")
+	rev := kvs[0].ModRevision
+	if err = db.CompareAndSet(ctx, "/a", CompareRevision(rev+1), "2"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err = db.CompareAndSet(ctx, "/a", CompareRevision(rev), "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.CompareAndSet(ctx, "/a", CompareValue("1"), "3"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err = db.CompareAndSet(ctx, "/a", CompareValue("2"), "3"); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ = db.Get(ctx, "/a"); kvs[0].Value.String() != "3" || kvs[0].ModRevision <= rev {
+		t.Fatalf("unexpected value: %+v", kvs[0])
+	}
+	// revision 0 matches key not exist
+	if err = db.CompareAndSet(ctx, "/b", CompareRevision(0), "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// lease kept by compare and set
+	time.Sleep(time.Millisecond * 1500)
+	if kvs, _ = db.Get(ctx, "/a"); len(kvs) != 0 {
+		t.Fatalf("lease not kept: %+v", kvs)
+	}
+	if kvs, _ = db.Get(ctx, "/b"); len(kvs) != 1 {
+		t.Fatalf("value without lease deleted: %+v", kvs)
+	}
+}
+
+func TestMemory_Revision(t *testing.T) {
+	db, err := newMemory(context.Background(), DatabaseConfig{Endpoints: []string{t.Name()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	events := make(chan Event, 10)
+	if err = db.Watch(ctx, "/a", func(_ context.Context, e Event) { events <- e }); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Set(ctx, "/a", "1", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Update(ctx, "/a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	kvs, _ := db.Get(ctx, "/a")
+	kv := kvs[0]
+	if kv.Version != 2 || kv.Lease == 0 || kv.CreateRevision == 0 || kv.ModRevision <= kv.CreateRevision {
+		t.Fatalf("unexpected kv: %+v", kv)
+	}
+	for _, version := range []int64{1, 2} {
+		select {
+		case e := <-events:
+			if e.Version != version || e.CreateRevision != kv.CreateRevision || e.Lease != kv.Lease || e.ModRevision != e.Revision {
+				t.Fatalf("unexpected event: %+v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("wait event timeout")
+		}
+	}
+}
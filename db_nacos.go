@@ -0,0 +1,772 @@
+package simple_registry
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	// nacosPageSize page size of list api
+	nacosPageSize = 500
+	// nacosListenTimeout long polling timeout of config listener,
+	// new configs are discovered at most after it
+	nacosListenTimeout = time.Second * 10
+	// nacosMetaKey instance metadata stores database key
+	nacosMetaKey = "registry_key"
+	// nacosMetaValue instance metadata stores database value
+	nacosMetaValue = "registry_value"
+	// nacosCodeNotFound returned by beat when instance not found
+	nacosCodeNotFound = 20404
+)
+
+type (
+	// nacos implements Database by nacos open api.
+	// keys under registry prefix are stored as instances of naming service,
+	// grouped by Config.Prefix and named by Instance.ServiceName, and changes are
+	// polled every heartbeat interval. other keys are stored in config service
+	// with "/" replaced by ":" as data id, and changes are pushed by config listener.
+	// config service has no lease, ttl is emulated in process.
+	// nacos has no revision and transaction, compare and set and txn are emulated in process.
+	nacos struct {
+		cli            *http.Client
+		endpoints      []string
+		username       string
+		password       string
+		group          string
+		prefix         string
+		registryPrefix string
+		interval       time.Duration
+		listenTimeout  time.Duration
+		tokenMu        sync.Mutex
+		token          string
+		casMu          sync.Mutex // serializes emulated compare and set
+		ttls           sync.Map   // key: string, value: *nacosTTL
+		beats          sync.Map   // key: string, value: *nacosBeat
+	}
+	nacosInstance struct {
+		InstanceId string            `json:"instanceId"`
+		Ip         string            `json:"ip"`
+		Port       int               `json:"port"`
+		Ephemeral  bool              `json:"ephemeral"`
+		Metadata   map[string]string `json:"metadata"`
+	}
+	// nacosBeat holds latest instance of heartbeat
+	nacosBeat struct {
+		mu  sync.Mutex
+		ins *nacosInstance
+	}
+	// nacosTTL emulated ttl of config, compared by pointer on expiring
+	nacosTTL struct {
+		timer *time.Timer
+	}
+	nacosConfig struct {
+		DataId  string `json:"dataId"`
+		Group   string `json:"group"`
+		Content string `json:"content"`
+	}
+)
+
+func newNacos(ctx context.Context, cfg Config) (n *nacos, err error) {
+	if len(cfg.Database.Endpoints) == 0 {
+		err = fmt.Errorf("nacos endpoints not provided")
+		return
+	}
+	scheme := "http://"
+	if cfg.Database.Tls != nil {
+		scheme = "https://"
+	}
+	endpoints := make([]string, 0, len(cfg.Database.Endpoints))
+	for _, endpoint := range cfg.Database.Endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = scheme + endpoint
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	n = &nacos{
+		cli: &http.Client{Transport: &http.Transport{
+			DialContext:     (&net.Dialer{Timeout: time.Second * 10}).DialContext,
+			TLSClientConfig: cfg.Database.tlsConfig(),
+		}},
+		endpoints:      endpoints,
+		username:       cfg.Database.Username,
+		password:       cfg.Database.Password,
+		group:          strings.ReplaceAll(strings.Trim(cfg.Prefix, "/"), "/", ":"),
+		prefix:         cfg.Prefix,
+		registryPrefix: cfg.getRegistryPrefix(),
+		interval:       time.Duration(cfg.HeartBeatInterval) * time.Second,
+		listenTimeout:  nacosListenTimeout,
+	}
+	if n.username != "" {
+		err = n.login(ctx)
+	}
+	return
+}
+
+func (n *nacos) Get(ctx context.Context, key string) (v []*KV, err error) {
+	if strings.HasSuffix(key, "/") {
+		return n.GetPrefix(ctx, key)
+	}
+	if n.isRegistryKey(key) {
+		var ins []*nacosInstance
+		if ins, err = n.listInstances(ctx, key); err != nil {
+			return
+		}
+		for _, instance := range ins {
+			if instance.Metadata[nacosMetaKey] == key {
+				v = append(v, n.instanceKV(instance))
+			}
+		}
+		return
+	}
+
+	query := url.Values{"dataId": {n.dataId(key)}, "group": {n.group}}
+	body, status, err := n.request(ctx, http.MethodGet, "/nacos/v1/cs/configs", query, nil)
+	switch {
+	case err != nil, status == http.StatusNotFound:
+		return
+	case status != http.StatusOK:
+		err = fmt.Errorf("nacos response %d: %s", status, strings.TrimSpace(string(body)))
+		return
+	}
+	v = append(v, &KV{Key: key, Value: g.NewVar(body)})
+	return
+}
+
+func (n *nacos) GetPrefix(ctx context.Context, key string) (v []*KV, err error) {
+	if n.isRegistryKey(key) || strings.HasPrefix(n.registryPrefix, key) {
+		var ins []*nacosInstance
+		if ins, err = n.listInstances(ctx, key); err != nil {
+			return
+		}
+		for _, instance := range ins {
+			v = append(v, n.instanceKV(instance))
+		}
+	}
+	if n.isRegistryKey(key) {
+		return
+	}
+
+	configs, err := n.listConfigs(ctx, key)
+	if err != nil {
+		return
+	}
+	for _, c := range configs {
+		v = append(v, &KV{Key: n.configKey(c.DataId), Value: g.NewVar(c.Content)})
+	}
+	return
+}
+
+func (n *nacos) Set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	if n.isRegistryKey(key) {
+		return n.register(ctx, key, gconv.String(value), ttl, len(keepalive) > 0 && keepalive[0])
+	}
+
+	form := url.Values{"dataId": {n.dataId(key)}, "group": {n.group}, "content": {gconv.String(value)}}
+	if _, err = n.expect(ctx, http.MethodPost, "/nacos/v1/cs/configs", nil, form); err != nil {
+		return
+	}
+	n.expireConfig(ctx, key, ttl, len(keepalive) > 0 && keepalive[0])
+	return
+}
+
+func (n *nacos) Delete(ctx context.Context, key string) (err error) {
+	if n.isRegistryKey(key) || strings.HasPrefix(n.registryPrefix, key) {
+		var ins []*nacosInstance
+		if ins, err = n.listInstances(ctx, key); err != nil {
+			return
+		}
+		for _, instance := range ins {
+			k := instance.Metadata[nacosMetaKey]
+			if k != key && !(strings.HasSuffix(key, "/") && strings.HasPrefix(k, key)) {
+				continue
+			}
+			if err = n.deregister(ctx, instance); err != nil {
+				return
+			}
+		}
+	}
+	if n.isRegistryKey(key) {
+		return
+	}
+
+	keys := []string{key}
+	if strings.HasSuffix(key, "/") {
+		keys = keys[:0]
+		var configs []*nacosConfig
+		if configs, err = n.listConfigs(ctx, key); err != nil {
+			return
+		}
+		for _, c := range configs {
+			keys = append(keys, n.configKey(c.DataId))
+		}
+	}
+	for _, k := range keys {
+		if t, ok := n.ttls.LoadAndDelete(k); ok {
+			t.(*nacosTTL).timer.Stop()
+		}
+		query := url.Values{"dataId": {n.dataId(k)}, "group": {n.group}}
+		if _, err = n.expect(ctx, http.MethodDelete, "/nacos/v1/cs/configs", query, nil); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Revoke ttl is emulated per key, same as Delete
+func (n *nacos) Revoke(ctx context.Context, key string) (err error) {
+	return n.Delete(ctx, key)
+}
+
+// Close stops emulated ttl timers and idle connections
+func (n *nacos) Close() (err error) {
+	n.ttls.Range(func(key, value any) bool {
+		if n.ttls.CompareAndDelete(key, value) {
+			value.(*nacosTTL).timer.Stop()
+		}
+		return true
+	})
+	n.cli.CloseIdleConnections()
+	return
+}
+
+func (n *nacos) Watch(ctx context.Context, key string, handler WatchHandler) (err error) {
+	if n.isRegistryKey(key) || strings.HasPrefix(n.registryPrefix, key) {
+		snapshot := n.snapshot(ctx, key, n.snapshotInstances)
+		go n.watch(ctx, key, snapshot, n.snapshotInstances, func(ctx context.Context) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(n.interval):
+			}
+		}, handler)
+	}
+	if n.isRegistryKey(key) {
+		return
+	}
+
+	snapshot := n.snapshot(ctx, key, n.snapshotConfigs)
+	go n.watch(ctx, key, snapshot, n.snapshotConfigs, func(ctx context.Context) {
+		n.listen(ctx, key)
+	}, handler)
+	return
+}
+
+// snapshot before watch, empty if failed and existing keys will be notified as created once reachable
+func (n *nacos) snapshot(ctx context.Context, key string,
+	fetch func(ctx context.Context, key string) (map[string]string, error)) map[string]string {
+	snapshot, err := fetch(ctx, key)
+	if err != nil {
+		g.Log().Warningf(ctx, "nacos failed to snapshot %s, watch from empty snapshot: %v", key, err)
+		return map[string]string{}
+	}
+	return snapshot
+}
+
+// watch diff snapshots after each wait and push changes to handler
+func (n *nacos) watch(ctx context.Context, key string, snapshot map[string]string,
+	fetch func(ctx context.Context, key string) (map[string]string, error),
+	wait func(ctx context.Context), handler WatchHandler) {
+	g.Log().Infof(ctx, "nacos watching %s", key)
+	defer func() {
+		g.Log().Infof(ctx, "nacos stop watching %s", key)
+	}()
+	for {
+		wait(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		current, err := fetch(ctx, key)
+		if err != nil {
+			g.Log().Warningf(ctx, "nacos watch %s retry: %v", key, err)
+			continue
+		}
+		for k, v := range current {
+			ori, ok := snapshot[k]
+			switch {
+			case !ok:
+				handler(ctx, Event{KV: KV{Key: k, Value: g.NewVar(v)}, Type: EventTypeCreate})
+			case ori != v:
+				handler(ctx, Event{KV: KV{Key: k, Value: g.NewVar(v)}, Type: EventTypeUpdate})
+			}
+		}
+		for k := range snapshot {
+			if _, ok := current[k]; !ok {
+				handler(ctx, Event{KV: KV{Key: k, Value: g.NewVar("")}, Type: EventTypeDelete})
+			}
+		}
+		snapshot = current
+	}
+}
+
+// listen configs under key by long polling, returns when any changed or timeout
+func (n *nacos) listen(ctx context.Context, key string) {
+	configs, err := n.listConfigs(ctx, key)
+	if err != nil || len(configs) == 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(n.interval):
+		}
+		return
+	}
+
+	builder := strings.Builder{}
+	for _, c := range configs {
+		sum := md5.Sum([]byte(c.Content))
+		builder.WriteString(c.DataId + "\x02" + c.Group + "\x02" + hex.EncodeToString(sum[:]) + "\x01")
+	}
+	form := url.Values{"Listening-Configs": {builder.String()}}
+	header := http.Header{"Long-Pulling-Timeout": {strconv.FormatInt(n.listenTimeout.Milliseconds(), 10)}}
+	_, _, _ = n.requestWithHeader(ctx, http.MethodPost, "/nacos/v1/cs/configs/listener", nil, form, header)
+}
+
+func (n *nacos) Update(ctx context.Context, key string, value interface{}) (err error) {
+	if !n.isRegistryKey(key) {
+		var kvs []*KV
+		if kvs, err = n.Get(ctx, key); err != nil {
+			return
+		}
+		if len(kvs) == 0 {
+			return ErrKeyNotFound
+		}
+		form := url.Values{"dataId": {n.dataId(key)}, "group": {n.group}, "content": {gconv.String(value)}}
+		_, err = n.expect(ctx, http.MethodPost, "/nacos/v1/cs/configs", nil, form)
+		return
+	}
+
+	ins, err := n.listInstances(ctx, key)
+	if err != nil {
+		return
+	}
+	for _, instance := range ins {
+		if instance.Metadata[nacosMetaKey] != key {
+			continue
+		}
+		// register again with new metadata at the same address
+		updated := n.newInstance(key, gconv.String(value))
+		updated.Ip, updated.Port, updated.Ephemeral = instance.Ip, instance.Port, instance.Ephemeral
+		if err = n.registerInstance(ctx, key, updated); err != nil {
+			return
+		}
+		if v, ok := n.beats.Load(key); ok {
+			b := v.(*nacosBeat)
+			b.mu.Lock()
+			b.ins = updated
+			b.mu.Unlock()
+		}
+		return
+	}
+	return ErrKeyNotFound
+}
+
+func (n *nacos) CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error) {
+	if n.isRegistryKey(key) {
+		return fmt.Errorf("%w: nacos compare and set of registry key %s", ErrNotSupported, key)
+	}
+	if cmp.target == compareRevision && cmp.revision > 0 {
+		return fmt.Errorf("%w: nacos revision of %s", ErrNotSupported, key)
+	}
+	n.casMu.Lock()
+	defer n.casMu.Unlock()
+	cur, err := n.current(ctx, key)
+	if err != nil {
+		return
+	}
+	if !cmp.match(cur) {
+		return &ConflictError{Key: key, Current: cur}
+	}
+	// publish keeps emulated ttl
+	form := url.Values{"dataId": {n.dataId(key)}, "group": {n.group}, "content": {gconv.String(value)}}
+	_, err = n.expect(ctx, http.MethodPost, "/nacos/v1/cs/configs", nil, form)
+	return
+}
+
+func (n *nacos) Create(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	n.casMu.Lock()
+	defer n.casMu.Unlock()
+	cur, err := n.current(ctx, key)
+	if err != nil {
+		return
+	}
+	if cur != nil {
+		return &ConflictError{Key: key, Current: cur}
+	}
+	return n.Set(ctx, key, value, ttl, keepalive...)
+}
+
+// Txn not supported, ops can't be applied atomically in nacos
+func (n *nacos) Txn(_ context.Context, _ []Guard, _ ...Op) (revision int64, err error) {
+	return 0, fmt.Errorf("%w: nacos txn", ErrNotSupported)
+}
+
+// current value of key, nil if not exist
+func (n *nacos) current(ctx context.Context, key string) (kv *KV, err error) {
+	kvs, err := n.Get(ctx, key)
+	if err == nil && len(kvs) > 0 {
+		kv = kvs[0]
+	}
+	return
+}
+
+func (n *nacos) register(ctx context.Context, key, value string, ttl int64, keepalive bool) (err error) {
+	ins := n.newInstance(key, value)
+	ins.Ephemeral = ttl > 0
+	if err = n.registerInstance(ctx, key, ins); err != nil {
+		return
+	}
+	if ttl > 0 && keepalive {
+		b := &nacosBeat{ins: ins}
+		n.beats.Store(key, b)
+		go n.beat(ctx, key, b)
+	}
+	return
+}
+
+func (n *nacos) registerInstance(ctx context.Context, key string, ins *nacosInstance) (err error) {
+	meta, _ := json.Marshal(ins.Metadata)
+	query := url.Values{
+		"serviceName": {n.serviceName(key)},
+		"groupName":   {n.group},
+		"ip":          {ins.Ip},
+		"port":        {strconv.Itoa(ins.Port)},
+		"ephemeral":   {strconv.FormatBool(ins.Ephemeral)},
+		"metadata":    {string(meta)},
+	}
+	_, err = n.expect(ctx, http.MethodPost, "/nacos/v1/ns/instance", query, nil)
+	return
+}
+
+func (n *nacos) deregister(ctx context.Context, ins *nacosInstance) (err error) {
+	query := url.Values{
+		"serviceName": {n.serviceName(ins.Metadata[nacosMetaKey])},
+		"groupName":   {n.group},
+		"ip":          {ins.Ip},
+		"port":        {strconv.Itoa(ins.Port)},
+	}
+	_, err = n.expect(ctx, http.MethodDelete, "/nacos/v1/ns/instance", query, nil)
+	return
+}
+
+// beat keep ephemeral instance alive, register again if nacos lost it
+func (n *nacos) beat(ctx context.Context, key string, b *nacosBeat) {
+	ticker := time.NewTicker(n.interval)
+	defer func() {
+		ticker.Stop()
+		n.beats.CompareAndDelete(key, b)
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			ins := b.ins
+			b.mu.Unlock()
+			beat, _ := json.Marshal(map[string]interface{}{
+				"serviceName": n.group + "@@" + n.serviceName(key),
+				"ip":          ins.Ip,
+				"port":        ins.Port,
+				"metadata":    ins.Metadata,
+				"scheduled":   true,
+			})
+			query := url.Values{
+				"serviceName": {n.serviceName(key)},
+				"groupName":   {n.group},
+				"ephemeral":   {"true"},
+				"beat":        {string(beat)},
+			}
+			body, err := n.expect(ctx, http.MethodPut, "/nacos/v1/ns/instance/beat", query, nil)
+			if err != nil {
+				g.Log().Warningf(ctx, "nacos failed to beat %s: %v", key, err)
+				continue
+			}
+			res := struct {
+				Code int `json:"code"`
+			}{}
+			if _ = json.Unmarshal(body, &res); res.Code == nacosCodeNotFound {
+				if err = n.registerInstance(ctx, key, ins); err != nil {
+					g.Log().Warningf(ctx, "nacos failed to register %s again: %v", key, err)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expireConfig emulate ttl of config, keepalive holds it until context done
+func (n *nacos) expireConfig(ctx context.Context, key string, ttl int64, keepalive bool) {
+	if t, ok := n.ttls.LoadAndDelete(key); ok {
+		t.(*nacosTTL).timer.Stop()
+	}
+	if ttl <= 0 {
+		return
+	}
+	t := &nacosTTL{}
+	t.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
+		if !n.ttls.CompareAndDelete(key, t) {
+			return
+		}
+		query := url.Values{"dataId": {n.dataId(key)}, "group": {n.group}}
+		if _, err := n.expect(context.Background(), http.MethodDelete, "/nacos/v1/cs/configs", query, nil); err != nil {
+			g.Log().Warningf(ctx, "nacos failed to expire %s: %v", key, err)
+		}
+	})
+	n.ttls.Store(key, t)
+	if keepalive {
+		t.timer.Stop()
+		go func() {
+			<-ctx.Done()
+			if v, ok := n.ttls.Load(key); ok && v == t {
+				t.timer.Reset(time.Duration(ttl) * time.Second)
+			}
+		}()
+	}
+}
+
+// listInstances of all services or service of key
+func (n *nacos) listInstances(ctx context.Context, key string) (ins []*nacosInstance, err error) {
+	var services []string
+	if name := n.serviceName(key); name != "" {
+		services = append(services, name)
+	} else if services, err = n.listServices(ctx); err != nil {
+		return
+	}
+
+	for _, service := range services {
+		query := url.Values{"serviceName": {service}, "groupName": {n.group}, "healthyOnly": {"false"}}
+		var body []byte
+		if body, err = n.expect(ctx, http.MethodGet, "/nacos/v1/ns/instance/list", query, nil); err != nil {
+			return
+		}
+		res := struct {
+			Hosts []*nacosInstance `json:"hosts"`
+		}{}
+		if err = json.Unmarshal(body, &res); err != nil {
+			return
+		}
+		for _, instance := range res.Hosts {
+			if k := instance.Metadata[nacosMetaKey]; k != "" && strings.HasPrefix(k, key) {
+				ins = append(ins, instance)
+			}
+		}
+	}
+	return
+}
+
+func (n *nacos) listServices(ctx context.Context) (services []string, err error) {
+	for page := 1; ; page++ {
+		query := url.Values{
+			"groupName": {n.group},
+			"pageNo":    {strconv.Itoa(page)},
+			"pageSize":  {strconv.Itoa(nacosPageSize)},
+		}
+		var body []byte
+		if body, err = n.expect(ctx, http.MethodGet, "/nacos/v1/ns/service/list", query, nil); err != nil {
+			return
+		}
+		res := struct {
+			Doms []string `json:"doms"`
+		}{}
+		if err = json.Unmarshal(body, &res); err != nil {
+			return
+		}
+		services = append(services, res.Doms...)
+		if len(res.Doms) < nacosPageSize {
+			return
+		}
+	}
+}
+
+// listConfigs under key by blur search
+func (n *nacos) listConfigs(ctx context.Context, key string) (configs []*nacosConfig, err error) {
+	pfx := n.dataId(key)
+	for page := 1; ; page++ {
+		query := url.Values{
+			"search":   {"blur"},
+			"dataId":   {pfx + "*"},
+			"group":    {n.group},
+			"pageNo":   {strconv.Itoa(page)},
+			"pageSize": {strconv.Itoa(nacosPageSize)},
+		}
+		var body []byte
+		if body, err = n.expect(ctx, http.MethodGet, "/nacos/v1/cs/configs", query, nil); err != nil {
+			return
+		}
+		res := struct {
+			PageItems []*nacosConfig `json:"pageItems"`
+		}{}
+		if err = json.Unmarshal(body, &res); err != nil {
+			return
+		}
+		for _, c := range res.PageItems {
+			if strings.HasPrefix(c.DataId, pfx) {
+				configs = append(configs, c)
+			}
+		}
+		if len(res.PageItems) < nacosPageSize {
+			return
+		}
+	}
+}
+
+func (n *nacos) snapshotInstances(ctx context.Context, key string) (m map[string]string, err error) {
+	ins, err := n.listInstances(ctx, key)
+	if err != nil {
+		return
+	}
+	m = make(map[string]string)
+	for _, instance := range ins {
+		m[instance.Metadata[nacosMetaKey]] = instance.Metadata[nacosMetaValue]
+	}
+	return
+}
+
+func (n *nacos) snapshotConfigs(ctx context.Context, key string) (m map[string]string, err error) {
+	configs, err := n.listConfigs(ctx, key)
+	if err != nil {
+		return
+	}
+	m = make(map[string]string)
+	for _, c := range configs {
+		m[n.configKey(c.DataId)] = c.Content
+	}
+	return
+}
+
+// newInstance from registry value, address comes from Instance
+func (n *nacos) newInstance(key, value string) *nacosInstance {
+	ins := new(Instance)
+	_ = json.Unmarshal([]byte(value), ins)
+	return &nacosInstance{
+		Ip:       ins.Host,
+		Port:     ins.Port,
+		Metadata: map[string]string{nacosMetaKey: key, nacosMetaValue: value},
+	}
+}
+
+func (n *nacos) instanceKV(ins *nacosInstance) *KV {
+	return &KV{Key: ins.Metadata[nacosMetaKey], Value: g.NewVar(ins.Metadata[nacosMetaValue])}
+}
+
+func (n *nacos) isRegistryKey(key string) bool {
+	return strings.HasPrefix(key, n.registryPrefix)
+}
+
+// serviceName of registry key, empty if key is not under any service
+func (n *nacos) serviceName(key string) string {
+	if !n.isRegistryKey(key) {
+		return ""
+	}
+	pos := strings.SplitN(strings.TrimPrefix(key, n.registryPrefix), "/", 2)
+	if len(pos) < 2 {
+		return ""
+	}
+	return pos[0]
+}
+
+func (n *nacos) dataId(key string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(key, n.prefix), "/", ":")
+}
+
+func (n *nacos) configKey(dataId string) string {
+	return n.prefix + strings.ReplaceAll(dataId, ":", "/")
+}
+
+func (n *nacos) login(ctx context.Context) (err error) {
+	form := url.Values{"username": {n.username}, "password": {n.password}}
+	body, status, err := n.do(ctx, http.MethodPost, "/nacos/v1/auth/login", nil, form, nil)
+	if err != nil {
+		return
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("nacos login response %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	res := struct {
+		AccessToken string `json:"accessToken"`
+	}{}
+	if err = json.Unmarshal(body, &res); err != nil {
+		return
+	}
+	n.tokenMu.Lock()
+	n.token = res.AccessToken
+	n.tokenMu.Unlock()
+	return
+}
+
+// expect status 200 and returns response body
+func (n *nacos) expect(ctx context.Context, method, path string, query, form url.Values) (body []byte, err error) {
+	body, status, err := n.request(ctx, method, path, query, form)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("nacos response %d: %s", status, strings.TrimSpace(string(body)))
+	}
+	return
+}
+
+func (n *nacos) request(ctx context.Context, method, path string, query, form url.Values) (body []byte, status int, err error) {
+	return n.requestWithHeader(ctx, method, path, query, form, nil)
+}
+
+// requestWithHeader with access token, login again once if token expired
+func (n *nacos) requestWithHeader(ctx context.Context, method, path string, query, form url.Values, header http.Header) (body []byte, status int, err error) {
+	body, status, err = n.do(ctx, method, path, query, form, header)
+	if err == nil && status == http.StatusForbidden && n.username != "" {
+		if err = n.login(ctx); err != nil {
+			return
+		}
+		body, status, err = n.do(ctx, method, path, query, form, header)
+	}
+	return
+}
+
+// do request to endpoints in order until one is reachable
+func (n *nacos) do(ctx context.Context, method, path string, query, form url.Values, header http.Header) (body []byte, status int, err error) {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	n.tokenMu.Lock()
+	if n.token != "" {
+		q.Set("accessToken", n.token)
+	}
+	n.tokenMu.Unlock()
+
+	for _, endpoint := range n.endpoints {
+		u := endpoint + path
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, method, u, strings.NewReader(form.Encode())); err != nil {
+			return
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		var resp *http.Response
+		if resp, err = n.cli.Do(req); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		body, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		status = resp.StatusCode
+		return
+	}
+	return
+}
@@ -0,0 +1,256 @@
+package simple_registry
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNacos implements a subset of nacos naming and config open api
+type fakeNacos struct {
+	mu        sync.Mutex
+	instances map[string]map[string]*nacosInstance // service : ip:port : instance
+	configs   map[string]*nacosConfig              // data id : config
+	beats     int
+	changed   chan struct{}
+}
+
+func newFakeNacos() (*fakeNacos, *httptest.Server) {
+	f := &fakeNacos{
+		instances: make(map[string]map[string]*nacosInstance),
+		configs:   make(map[string]*nacosConfig),
+		changed:   make(chan struct{}),
+	}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeNacos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	service := r.Form.Get("groupName") + "@@" + r.Form.Get("serviceName")
+	address := r.Form.Get("ip") + ":" + r.Form.Get("port")
+	switch r.Method + " " + r.URL.Path {
+	case "POST /nacos/v1/ns/instance":
+		port, _ := strconv.Atoi(r.Form.Get("port"))
+		ins := &nacosInstance{Ip: r.Form.Get("ip"), Port: port, Ephemeral: r.Form.Get("ephemeral") == "true"}
+		_ = json.Unmarshal([]byte(r.Form.Get("metadata")), &ins.Metadata)
+		if f.instances[service] == nil {
+			f.instances[service] = make(map[string]*nacosInstance)
+		}
+		f.instances[service][address] = ins
+		_, _ = w.Write([]byte("ok"))
+	case "DELETE /nacos/v1/ns/instance":
+		delete(f.instances[service], address)
+		_, _ = w.Write([]byte("ok"))
+	case "PUT /nacos/v1/ns/instance/beat":
+		f.beats++
+		_, _ = w.Write([]byte(`{"code":10200}`))
+	case "GET /nacos/v1/ns/instance/list":
+		hosts := make([]*nacosInstance, 0)
+		for _, ins := range f.instances[service] {
+			hosts = append(hosts, ins)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"hosts": hosts})
+	case "GET /nacos/v1/ns/service/list":
+		doms := make([]string, 0)
+		for name := range f.instances {
+			if group := r.Form.Get("groupName") + "@@"; strings.HasPrefix(name, group) {
+				doms = append(doms, strings.TrimPrefix(name, group))
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"count": len(doms), "doms": doms})
+	case "GET /nacos/v1/cs/configs":
+		if r.Form.Get("search") == "blur" {
+			items := make([]*nacosConfig, 0)
+			for id, c := range f.configs {
+				if strings.HasPrefix(id, strings.TrimSuffix(r.Form.Get("dataId"), "*")) {
+					items = append(items, c)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"totalCount": len(items), "pageItems": items})
+			return
+		}
+		c, ok := f.configs[r.Form.Get("dataId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(c.Content))
+	case "POST /nacos/v1/cs/configs":
+		f.configs[r.Form.Get("dataId")] = &nacosConfig{
+			DataId:  r.Form.Get("dataId"),
+			Group:   r.Form.Get("group"),
+			Content: r.Form.Get("content"),
+		}
+		f.notify()
+		_, _ = w.Write([]byte("true"))
+	case "DELETE /nacos/v1/cs/configs":
+		delete(f.configs, r.Form.Get("dataId"))
+		f.notify()
+		_, _ = w.Write([]byte("true"))
+	case "POST /nacos/v1/cs/configs/listener":
+		// returns immediately if any md5 not match
+		for _, line := range strings.Split(r.Form.Get("Listening-Configs"), "\x01") {
+			pos := strings.Split(line, "\x02")
+			if len(pos) != 3 {
+				continue
+			}
+			c, ok := f.configs[pos[0]]
+			if !ok {
+				return
+			}
+			if sum := md5.Sum([]byte(c.Content)); hex.EncodeToString(sum[:]) != pos[2] {
+				return
+			}
+		}
+		timeout, _ := strconv.Atoi(r.Header.Get("Long-Pulling-Timeout"))
+		changed := f.changed
+		f.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(timeout) * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		f.mu.Lock()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// notify config listeners, caller must hold lock
+func (f *fakeNacos) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func TestNacos(t *testing.T) {
+	fake, server := newFakeNacos()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := Config{Database: DatabaseConfig{Endpoints: []string{server.URL}}, Prefix: "/test-registry/", HeartBeatInterval: 1}
+	cfg.check()
+	db, err := newNacos(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.group != "test-registry" {
+		t.Fatalf("unexpected group: %s", db.group)
+	}
+	db.listenTimeout = time.Millisecond * 500
+
+	events := make(chan Event, 10)
+	for _, pfx := range []string{cfg.getRegistryPrefix(), cfg.getStoragePrefix()} {
+		if err = db.Watch(ctx, pfx, func(_ context.Context, e Event) {
+			events <- e
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var expect = func(typ EventType, key string) {
+		select {
+		case e := <-events:
+			if e.Type != typ || e.Key != key {
+				t.Fatalf("unexpected event: %s %s", e.Type, e.Key)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("wait %s %s event timeout", typ, key)
+		}
+	}
+
+	// registry instance
+	ins := NewInstance("svc").WithAddress("127.0.0.1", 8080).fillInfo()
+	key := ins.registryIdentity(cfg.getRegistryPrefix())
+	if err = db.Set(ctx, key, ins.String(), cfg.HeartBeatInterval, true); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, key)
+	kvs, err := db.GetPrefix(ctx, cfg.getRegistryPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Key != key || kvs[0].Value.String() != ins.String() {
+		t.Fatalf("get instances not match: %+v", kvs)
+	}
+	updated := ins.clone().WithMetaData(map[string]interface{}{"version": "v2"})
+	if err = db.Update(ctx, key, updated.String()); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, key)
+	time.Sleep(time.Millisecond * 1500)
+	fake.mu.Lock()
+	beats := fake.beats
+	fake.mu.Unlock()
+	if beats == 0 {
+		t.Fatal("instance heartbeat not sent")
+	}
+	if err = db.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeDelete, key)
+
+	// storage config
+	cfgKey := cfg.getStoragePrefix() + "test/key"
+	if err = db.Set(ctx, cfgKey, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, cfgKey)
+	if _, ok := fake.configs["storage:test:key"]; !ok {
+		t.Fatal("unexpected config data id")
+	}
+	if err = db.Set(ctx, cfgKey, "value1", 0); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeUpdate, cfgKey)
+	if kvs, err = db.Get(ctx, cfgKey); err != nil || len(kvs) != 1 || kvs[0].Value.String() != "value1" {
+		t.Fatalf("get config not match: %v %+v", err, kvs)
+	}
+	if err = db.Set(ctx, cfg.getStoragePrefix()+"test/ttl", "value", 2); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeCreate, cfg.getStoragePrefix()+"test/ttl")
+	expect(EventTypeDelete, cfg.getStoragePrefix()+"test/ttl")
+	if err = db.Delete(ctx, cfg.getStoragePrefix()); err != nil {
+		t.Fatal(err)
+	}
+	expect(EventTypeDelete, cfgKey)
+
+	// unsupported
+	if err = db.CompareAndSet(ctx, key, CompareValue("v"), "v1"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+	if err = db.CompareAndSet(ctx, cfgKey, CompareRevision(1), "v1"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+	if _, err = db.Txn(ctx, nil, OpSet(cfgKey, "v1")); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+}
+
+func TestNacos_GetError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("internal error"))
+	}))
+	defer server.Close()
+	cfg := Config{Database: DatabaseConfig{Endpoints: []string{server.URL}}}
+	cfg.check()
+	db, err := newNacos(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs, err := db.Get(context.Background(), cfg.getStoragePrefix()+"key"); err == nil {
+		t.Fatalf("expect error, got %+v", kvs)
+	}
+}
@@ -0,0 +1,140 @@
+package simple_registry
+
+import (
+	"sync"
+)
+
+const defaultDeliveryBufferSize = 1024
+
+// overflow policy define
+const (
+	// OverflowBlock wait until buffer has space, watch of database and cache updates of the whole registry
+	// are blocked meanwhile, only for handlers never stall
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropOldest drop the oldest pending event
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowCoalesce merge pending events of the same instance into one, drop the oldest if still full
+	OverflowCoalesce OverflowPolicy = "coalesce"
+)
+
+type (
+	// OverflowPolicy of subscriber buffer
+	OverflowPolicy string
+	// eventQueue bounded and ordered pending events of one subscriber
+	eventQueue struct {
+		cfg    DeliveryConfig
+		mu     sync.Mutex
+		cond   *sync.Cond
+		items  []ServiceEvent
+		closed bool
+	}
+)
+
+func newEventQueue(cfg DeliveryConfig) *eventQueue {
+	cfg.check()
+	q := &eventQueue{cfg: cfg}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push event by overflow policy, returns false if closed
+func (q *eventQueue) push(e ServiceEvent) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && len(q.items) >= q.cfg.BufferSize {
+		switch q.cfg.Overflow {
+		case OverflowCoalesce:
+			if q.coalesce(e) {
+				return true
+			}
+			q.items = q.items[1:]
+		case OverflowDropOldest:
+			q.items = q.items[1:]
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, e)
+	q.cond.Broadcast()
+	return true
+}
+
+// pop the oldest event, wait if empty, returns false if closed
+func (q *eventQueue) pop() (e ServiceEvent, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && len(q.items) == 0 {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return
+	}
+	e, q.items = q.items[0], q.items[1:]
+	q.cond.Broadcast()
+	return e, true
+}
+
+// close queue, pending events are discarded and blocked push returns
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.items = nil
+	q.cond.Broadcast()
+}
+
+// coalesce e into pending event of the same instance, caller must hold lock
+func (q *eventQueue) coalesce(e ServiceEvent) bool {
+	id := e.identity()
+	if id == "" {
+		return false
+	}
+	for i := len(q.items) - 1; i >= 0; i-- {
+		pending := q.items[i]
+		if pending.identity() != id {
+			continue
+		}
+		merged, keep := pending.merge(e)
+		if keep {
+			q.items[i] = merged
+		} else {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+// identity of changed instance, empty if unknown
+func (e ServiceEvent) identity() string {
+	switch {
+	case e.New != nil:
+		return e.New.Identity()
+	case e.Old != nil:
+		return e.Old.Identity()
+	}
+	return ""
+}
+
+// merge later event of the same instance, keep false if nothing changed in total
+func (e ServiceEvent) merge(later ServiceEvent) (merged ServiceEvent, keep bool) {
+	merged = later
+	merged.Old = e.Old
+	switch {
+	case e.Type == EventTypeCreate && later.Type == EventTypeDelete:
+		// created and deleted
+		return merged, false
+	case e.Type == EventTypeCreate:
+		merged.Type = EventTypeCreate
+	case e.Type == EventTypeDelete && later.Type == EventTypeCreate:
+		// deleted and created again
+		merged.Type = EventTypeUpdate
+	case e.Type == EventTypeUpdate && (later.Type == EventTypeHealthy || later.Type == EventTypeUnhealthy):
+		// data changed, new instance carries health status as well
+		merged.Type = EventTypeUpdate
+	}
+	return merged, true
+}
@@ -0,0 +1,147 @@
+package simple_registry
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+func testEvent(typ EventType, id string, version int) ServiceEvent {
+	ins := NewInstance("svc").WithMetaData(map[string]interface{}{"version": version})
+	ins.Id, ins.Host = id, "127.0.0.1"
+	e := ServiceEvent{Type: typ, ServiceName: "svc", New: ins}
+	if typ == EventTypeDelete {
+		e.Old, e.New = ins, nil
+	}
+	return e
+}
+
+func TestEventQueue_DropOldest(t *testing.T) {
+	q := newEventQueue(DeliveryConfig{BufferSize: 2, Overflow: OverflowDropOldest})
+	for i := 0; i < 3; i++ {
+		q.push(testEvent(EventTypeUpdate, fmt.Sprint(i), i))
+	}
+	for _, id := range []string{"1", "2"} {
+		if e, _ := q.pop(); e.New.Id != id {
+			t.Fatalf("expect %s, got %s", id, e.New.Id)
+		}
+	}
+}
+
+func TestEventQueue_Coalesce(t *testing.T) {
+	q := newEventQueue(DeliveryConfig{BufferSize: 2, Overflow: OverflowCoalesce})
+	q.push(testEvent(EventTypeCreate, "a", 1))
+	q.push(testEvent(EventTypeCreate, "b", 1))
+	// merged into create of a
+	q.push(testEvent(EventTypeUpdate, "a", 2))
+	// created and deleted, both dropped
+	q.push(testEvent(EventTypeDelete, "b", 1))
+	q.push(testEvent(EventTypeUpdate, "c", 1))
+
+	e, _ := q.pop()
+	if e.Type != EventTypeCreate || e.New.Id != "a" || e.New.Meta["version"] != 2 {
+		t.Fatalf("unexpected coalesced event: %s %+v", e.Type, e.New)
+	}
+	if e, _ = q.pop(); e.New.Id != "c" {
+		t.Fatalf("unexpected event: %+v", e.New)
+	}
+
+	// data change kept when merged with health change
+	q.push(testEvent(EventTypeUpdate, "d", 1))
+	q.push(testEvent(EventTypeUpdate, "e", 1))
+	q.push(testEvent(EventTypeUnhealthy, "d", 1))
+	if e, _ = q.pop(); e.Type != EventTypeUpdate || e.New.Id != "d" {
+		t.Fatalf("unexpected merged event: %s %+v", e.Type, e.New)
+	}
+}
+
+func TestEventQueue_Default(t *testing.T) {
+	q := newEventQueue(DeliveryConfig{BufferSize: 1})
+	pushed := make(chan bool)
+	go func() {
+		q.push(testEvent(EventTypeUpdate, "a", 1))
+		pushed <- q.push(testEvent(EventTypeUpdate, "b", 1))
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("push blocked by default if full")
+	}
+	if e, _ := q.pop(); e.New.Id != "b" {
+		t.Fatalf("unexpected event: %+v", e.New)
+	}
+}
+
+func TestEventQueue_Block(t *testing.T) {
+	q := newEventQueue(DeliveryConfig{BufferSize: 1, Overflow: OverflowBlock})
+	q.push(testEvent(EventTypeUpdate, "a", 1))
+	pushed := make(chan bool)
+	go func() { pushed <- q.push(testEvent(EventTypeUpdate, "b", 1)) }()
+	select {
+	case <-pushed:
+		t.Fatal("push not blocked if full")
+	case <-time.After(time.Millisecond * 100):
+	}
+	if e, _ := q.pop(); e.New.Id != "a" {
+		t.Fatalf("unexpected event: %+v", e.New)
+	}
+	if !<-pushed {
+		t.Fatal("push failed after pop")
+	}
+
+	// blocked push returns after closed
+	go func() { pushed <- q.push(testEvent(EventTypeUpdate, "c", 1)) }()
+	time.Sleep(time.Millisecond * 100)
+	q.close()
+	if <-pushed {
+		t.Fatal("push succeed after closed")
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop succeed after closed")
+	}
+}
+
+func TestRegisterEventHandler_Order(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	versions := make(chan int, 100)
+	unsubscribe := cli.Registry.RegisterEventHandler(func(i *Instance, e EventType) {
+		// slow handler
+		time.Sleep(time.Millisecond)
+		versions <- gconv.Int(i.Meta["version"])
+	})
+	for i := 1; i <= 20; i++ {
+		if err = cli.Registry.UpdateMeta(ctx, map[string]interface{}{"version": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 20; i++ {
+		select {
+		case v := <-versions:
+			if v != i {
+				t.Fatalf("expect version %d, got %d", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait version %d timeout", i)
+		}
+	}
+
+	unsubscribe()
+	if err = cli.Registry.UpdateMeta(ctx, map[string]interface{}{"version": 0}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-versions:
+		t.Fatalf("unexpected event after unsubscribe: %d", v)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
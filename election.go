@@ -0,0 +1,244 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+type (
+	// Election of leader among instances of the same service, only one instance is leader at a time
+	Election interface {
+		// IsLeader returns true if campaigning instance is leader, leadership is dropped
+		// if not confirmed within heartbeat interval
+		IsLeader() bool
+		// Leader copy of current leader, nil if no leader
+		Leader() *Instance
+		// Changes of leader, nil if no leader. only the latest change is kept if not received in time,
+		// closed after resigned
+		Changes() <-chan *Instance
+		// Resign leadership and stop campaigning
+		Resign(ctx context.Context) (err error)
+	}
+
+	election struct {
+		rg       *registration
+		key      string
+		value    string // value put by campaigning instance
+		identity string
+		mu       sync.RWMutex // protect leader
+		leader   *Instance
+		changes  chan *Instance
+		wake     chan struct{}
+		checked  time.Time // last read or created election key, accessed by campaign loop only
+		cancel   context.CancelFunc
+		done     chan struct{} // closed after campaign loop stopped
+		once     sync.Once
+		err      error
+	}
+)
+
+func (rg *registration) Campaign(ctx context.Context) (e Election, err error) {
+	r := rg.r
+	if !atomicCAS(r.cli) {
+		return nil, ErrNotSupported
+	}
+	if e, err = rg.campaigning(); e != nil || err != nil {
+		return
+	}
+
+	el := &election{
+		rg:       rg,
+		key:      r.cfg.getElectionPrefix() + rg.ins.ServiceName,
+		value:    rg.ins.String(),
+		identity: rg.ins.Identity(),
+		changes:  make(chan *Instance, 1),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	// watch without holding lock of registration, database may be slow
+	ctx, el.cancel = context.WithCancel(ctx)
+	if err = r.cli.Watch(ctx, el.key, func(_ context.Context, e Event) {
+		// some databases watch key as prefix
+		if e.Key == el.key {
+			el.notify()
+		}
+	}); err != nil {
+		el.cancel()
+		return
+	}
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	// check again, deregistered or campaigned by others while watching
+	if rg.done || rg.election != nil {
+		el.cancel()
+		if rg.done {
+			return nil, ErrNotRegistered
+		}
+		return rg.election, nil
+	}
+	go el.run(ctx)
+	go func() {
+		<-ctx.Done()
+		_ = el.Resign(context.WithoutCancel(ctx))
+	}()
+	rg.election = el
+	return el, nil
+}
+
+// campaigning election, ErrNotRegistered if deregistered
+func (rg *registration) campaigning() (e Election, err error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.done {
+		return nil, ErrNotRegistered
+	}
+	if rg.election != nil {
+		return rg.election, nil
+	}
+	return
+}
+
+// run campaigns on leader changed or every heartbeat interval until ctx done
+func (e *election) run(ctx context.Context) {
+	defer close(e.done)
+	ticker := time.NewTicker(time.Duration(e.rg.r.cfg.HeartBeatInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// campaign by creating election key with lease if absent, leader is the one created it.
+// key is read first, so that lease or session is not created on every tick while leader exists
+func (e *election) campaign(ctx context.Context) {
+	r := e.rg.r
+	start := time.Now()
+	kvs, err := r.cli.Get(ctx, e.key)
+	if err == nil && len(kvs) > 0 {
+		e.setLeader(kvs[0].Value.String())
+		e.checked = start
+		return
+	}
+	if err == nil {
+		err = r.cli.Create(ctx, e.key, e.value, r.cfg.HeartBeatInterval, true)
+	}
+	var ce *ConflictError
+	switch {
+	case err == nil:
+		e.setLeader(e.value)
+	case errors.As(err, &ce) && ce.Current != nil:
+		e.setLeader(ce.Current.Value.String())
+	case errors.As(err, &ce):
+		// deleted after create failed, campaign again
+		e.setLeader("")
+		e.notify()
+	case ctx.Err() == nil:
+		g.Log().Warningf(ctx, "registry failed to campaign %s: %v", e.key, err)
+		// lease of leader may be expired if not confirmed within heartbeat interval
+		if time.Since(e.checked) > time.Duration(r.cfg.HeartBeatInterval)*time.Second {
+			e.setLeader("")
+		}
+		return
+	default:
+		return
+	}
+	e.checked = start
+}
+
+// setLeader from value of election key and push change if leader changed
+func (e *election) setLeader(value string) {
+	var leader *Instance
+	if value != "" {
+		leader = new(Instance)
+		if err := g.NewVar(value).Struct(&leader); err != nil {
+			g.Log().Warningf(context.Background(), "registry invalid leader of %s: %v", e.key, err)
+			return
+		}
+	}
+
+	e.mu.Lock()
+	if (e.leader == nil && leader == nil) || (e.leader != nil && leader != nil && e.leader.Identity() == leader.Identity()) {
+		e.mu.Unlock()
+		return
+	}
+	e.leader = leader
+	e.mu.Unlock()
+
+	// keep the latest change only, campaign loop is the only writer
+	select {
+	case <-e.changes:
+	default:
+	}
+	if leader != nil {
+		leader = leader.clone()
+	}
+	e.changes <- leader
+}
+
+func (e *election) notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *election) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader != nil && e.leader.Identity() == e.identity
+}
+
+// Leader from local cache if found, so that changes after campaigned are seen
+func (e *election) Leader() *Instance {
+	e.mu.RLock()
+	leader := e.leader
+	e.mu.RUnlock()
+	if leader == nil {
+		return nil
+	}
+	if service, err := e.rg.r.GetService(context.Background(), leader.ServiceName); err == nil {
+		if ins := service.get(leader.Identity()); ins != nil {
+			return ins.clone()
+		}
+	}
+	return leader.clone()
+}
+
+func (e *election) Changes() <-chan *Instance {
+	return e.changes
+}
+
+// Resign stops campaigning, and deletes election key if leader so that others take over at once
+func (e *election) Resign(ctx context.Context) error {
+	e.once.Do(func() {
+		e.cancel()
+		<-e.done
+		_, err := e.rg.r.cli.Txn(ctx, []Guard{{Key: e.key, Compare: CompareValue(e.value)}}, OpDelete(e.key))
+		if !errors.Is(err, ErrConflict) {
+			e.err = err
+		}
+
+		e.mu.Lock()
+		e.leader = nil
+		e.mu.Unlock()
+		close(e.changes)
+
+		e.rg.mu.Lock()
+		if e.rg.election == e {
+			e.rg.election = nil
+		}
+		e.rg.mu.Unlock()
+	})
+	return e.err
+}
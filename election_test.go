@@ -0,0 +1,129 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}}
+	var (
+		rgs       []Registration
+		elections []Election
+	)
+	next := func(e Election) *Instance {
+		t.Helper()
+		select {
+		case ins := <-e.Changes():
+			return ins
+		case <-time.After(time.Second):
+			t.Fatal("wait leader change timeout")
+		}
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		cli, err := New(ctx, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.Registry.Campaign(ctx); err != ErrNotRegistered {
+			t.Fatalf("expect not registered, got %v", err)
+		}
+		rg, err := cli.Registry.Register(ctx, NewInstance("svc").WithAddress("127.0.0.1", 8080+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := rg.Campaign(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := rg.Campaign(ctx); again != e {
+			t.Fatal("expect election in progress returned")
+		}
+		rgs, elections = append(rgs, rg), append(elections, e)
+		// the first one campaigned is leader
+		if ins := next(e); ins == nil || ins.Identity() != rgs[0].Instance().Identity() {
+			t.Fatalf("unexpected leader: %v", ins)
+		}
+	}
+
+	if !elections[0].IsLeader() || elections[1].IsLeader() {
+		t.Fatal("only the first instance should be leader")
+	}
+
+	// leader reflects changes of instance
+	if err := rgs[0].UpdateMeta(ctx, map[string]interface{}{"version": "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if ins := elections[1].Leader(); ins == nil || ins.Meta["version"] != "v2" {
+		t.Fatalf("leader not updated: %v", ins)
+	}
+
+	// deregister resigns and the other takes over
+	if err := rgs[0].Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-elections[0].Changes(); ok {
+		t.Fatal("changes not closed after resigned")
+	}
+	if ins := next(elections[1]); ins == nil || ins.Identity() != rgs[1].Instance().Identity() || !elections[1].IsLeader() {
+		t.Fatalf("leader not taken over: %v", ins)
+	}
+
+	if err := elections[1].Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elections[1].IsLeader() || elections[1].Leader() != nil {
+		t.Fatal("still leader after resigned")
+	}
+}
+
+func TestElection_Unconfirmed(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}, HeartBeatInterval: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := cli.Registry.Register(ctx, NewInstance("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cli.Registry.(*registry)
+	db := &flakyGetDB{Database: r.cli}
+	rg := &registration{r: &registry{cfg: r.cfg, cli: db}, ins: src.Instance()}
+	e, err := rg.Campaign(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = e.Resign(ctx) }()
+	select {
+	case ins := <-e.Changes():
+		if ins == nil || !e.IsLeader() {
+			t.Fatalf("unexpected leader: %v", ins)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait leader timeout")
+	}
+
+	// leadership dropped if not confirmed within heartbeat interval, e.g. partitioned from database
+	db.fails.Store(1 << 30)
+	select {
+	case ins := <-e.Changes():
+		if ins != nil || e.IsLeader() {
+			t.Fatalf("leadership not dropped: %v", ins)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("wait leadership dropped timeout")
+	}
+}
+
+func TestElection_NotSupported(t *testing.T) {
+	rg := &registration{r: &registry{cli: &nacos{}}, ins: NewInstance("svc")}
+	if _, err := rg.Campaign(context.Background()); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+}
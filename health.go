@@ -0,0 +1,214 @@
+package simple_registry
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	defaultHealthCheckInterval  = time.Second * 5
+	defaultHealthCheckTimeout   = time.Second * 3
+	defaultHealthCheckPathKey   = "health_check_path"
+	defaultHealthCheckThreshold = 1
+)
+
+// health check event type define
+const (
+	// EventTypeUnhealthy instance marked unhealthy in local cache by health check
+	EventTypeUnhealthy EventType = "unhealthy"
+	// EventTypeHealthy unhealthy instance recovered
+	EventTypeHealthy EventType = "healthy"
+)
+
+type (
+	// HealthCheckFunc custom health check, returns error if instance unhealthy
+	HealthCheckFunc func(ctx context.Context, ins *Instance) (err error)
+	// HealthCheckConfig of active health check on cached instances
+	HealthCheckConfig struct {
+		Interval  time.Duration   `json:"interval"`  // default 5s
+		Timeout   time.Duration   `json:"timeout"`   // timeout of each check, default 3s
+		PathKey   string          `json:"path_key"`  // meta key of http check path, tcp dial if not exist in meta, default "health_check_path"
+		Threshold int             `json:"threshold"` // consecutive failures to mark unhealthy, default 1
+		Services  []string        `json:"services"`  // services to check, all if empty
+		Check     HealthCheckFunc `json:"-"`         // custom check instead of tcp and http
+	}
+)
+
+func (c *HealthCheckConfig) check() {
+	if c.Interval <= 0 {
+		c.Interval = defaultHealthCheckInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultHealthCheckTimeout
+	}
+	if c.PathKey == "" {
+		c.PathKey = defaultHealthCheckPathKey
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = defaultHealthCheckThreshold
+	}
+}
+
+// probe instance by custom func, http get if path in meta, otherwise tcp dial
+func (c *HealthCheckConfig) probe(ctx context.Context, ins *Instance) (err error) {
+	if c.Check != nil {
+		return c.Check(ctx, ins)
+	}
+	addr := net.JoinHostPort(ins.Host, strconv.Itoa(ins.Port))
+	if path, ok := ins.Meta[c.PathKey]; ok {
+		var req *http.Request
+		u := fmt.Sprintf("http://%s/%s", addr, strings.TrimPrefix(gconv.String(path), "/"))
+		if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u, nil); err != nil {
+			return
+		}
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			err = fmt.Errorf("health check response %d", resp.StatusCode)
+		}
+		return
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return
+	}
+	return conn.Close()
+}
+
+func (r *registry) StartHealthCheck(ctx context.Context, config ...HealthCheckConfig) {
+	cfg := HealthCheckConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.check()
+	go r.healthCheck(ctx, cfg)
+}
+
+func (r *registry) healthCheck(ctx context.Context, cfg HealthCheckConfig) {
+	g.Log().Infof(ctx, "registry health check started, interval=%v", cfg.Interval)
+	defer func() {
+		r.recoverHealth()
+		g.Log().Infof(ctx, "registry health check stopped")
+	}()
+
+	failures := make(map[string]int) // identity : consecutive failures
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		instances := r.healthCheckTargets(cfg.Services)
+		errs := make([]error, len(instances))
+		wg := sync.WaitGroup{}
+		for i, instance := range instances {
+			wg.Add(1)
+			go func(i int, instance *Instance) {
+				defer wg.Done()
+				cctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+				defer cancel()
+				errs[i] = cfg.probe(cctx, instance)
+			}(i, instance)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		checked := make(map[string]int, len(instances))
+		for i, instance := range instances {
+			id := instance.Identity()
+			if errs[i] == nil {
+				r.setHealth(instance.ServiceName, id, true)
+				continue
+			}
+			checked[id] = failures[id] + 1
+			if checked[id] >= cfg.Threshold && r.setHealth(instance.ServiceName, id, false) {
+				g.Log().Warningf(ctx, "registry health check %s failed: %v", id, errs[i])
+			}
+		}
+		failures = checked
+	}
+}
+
+// healthCheckTargets copy of cached instances of services, all if services empty
+func (r *registry) healthCheckTargets(services []string) (instances []*Instance) {
+	r.cache.Range(func(key, value interface{}) bool {
+		if len(services) == 0 || slices.Contains(services, key.(string)) {
+			instances = append(instances, value.(*Service).Instances()...)
+		}
+		return true
+	})
+	return
+}
+
+// setHealth of cached instance, status in database is kept to recover,
+// returns true if changed and event pushed
+func (r *registry) setHealth(serviceName, id string, healthy bool) bool {
+	r.healthMu.Lock()
+	defer r.healthMu.Unlock()
+	v, ok := r.cache.Load(serviceName)
+	if !ok {
+		return false
+	}
+	service := v.(*Service)
+	cur := service.get(id)
+	if cur == nil {
+		return false
+	}
+
+	ins := cur.clone()
+	typ := EventTypeHealthy
+	if healthy {
+		status, marked := r.unhealthy.LoadAndDelete(id)
+		if !marked {
+			return false
+		}
+		ins.Status = status.(InstanceStatus)
+	} else {
+		if _, marked := r.unhealthy.Load(id); marked {
+			return false
+		}
+		r.unhealthy.Store(id, ins.Status)
+		ins.Status = InstanceStatusUnhealthy
+		typ = EventTypeUnhealthy
+	}
+	service.upsert(ins)
+	r.notify(serviceName, typ, cur, ins, 0)
+	return true
+}
+
+// recoverHealth of all instances marked unhealthy
+func (r *registry) recoverHealth() {
+	r.cache.Range(func(key, value interface{}) bool {
+		for _, instance := range value.(*Service).Instances() {
+			r.setHealth(key.(string), instance.Identity(), true)
+		}
+		return true
+	})
+}
+
+// withHealth applies health check result on instance from database, caller must hold healthMu
+func (r *registry) withHealth(ins *Instance) *Instance {
+	if _, marked := r.unhealthy.Load(ins.Identity()); marked {
+		r.unhealthy.Store(ins.Identity(), ins.Status)
+		ins.Status = InstanceStatusUnhealthy
+	}
+	return ins
+}
@@ -0,0 +1,133 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tcp: alive listener and closed port
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	_ = dead.Close()
+	// http: status switchable
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	register := func(name, id, addr string, meta map[string]interface{}) {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := net.LookupPort("tcp", port)
+		ins := NewInstance(name).WithAddress(host, p).WithMetaData(meta)
+		ins.Id = id
+		if _, err := cli.Registry.Register(ctx, ins); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("tcp", "alive", lis.Addr().String(), nil)
+	register("tcp", "dead", dead.Addr().String(), nil)
+	register("http", "web", srv.Listener.Addr().String(), map[string]interface{}{"health_check_path": "/healthz"})
+	time.Sleep(time.Millisecond * 100)
+
+	events := make(chan string, 10)
+	cli.Registry.RegisterEventHandler(func(i *Instance, e EventType) {
+		if e == EventTypeUnhealthy || e == EventTypeHealthy {
+			events <- i.Id + ":" + string(e)
+		}
+	})
+	checkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cli.Registry.StartHealthCheck(checkCtx, HealthCheckConfig{Interval: time.Millisecond * 50, Threshold: 2})
+
+	expect := func(event string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e != event {
+				t.Fatalf("expect %s, got %s", event, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("wait %s timeout", event)
+		}
+	}
+	expect("dead:unhealthy")
+	service, _ := cli.Registry.GetService(ctx, "tcp")
+	if serving := service.ServingInstances(); len(serving) != 1 || serving[0].Id != "alive" {
+		t.Fatalf("serving instances not match: %+v", serving)
+	}
+	// cache rebuilt on register keeps unhealthy mark
+	register("other", "other", lis.Addr().String(), nil)
+	service, _ = cli.Registry.GetService(ctx, "tcp")
+	if serving := service.ServingInstances(); len(serving) != 1 || serving[0].Id != "alive" {
+		t.Fatalf("unhealthy instance back in rotation: %+v", serving)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	expect("web:unhealthy")
+	status.Store(http.StatusOK)
+	expect("web:healthy")
+	service, _ = cli.Registry.GetService(ctx, "http")
+	if ins := service.Instances()[0]; ins.Status != InstanceStatusServing {
+		t.Fatalf("status not recovered: %s", ins.Status)
+	}
+
+	// unhealthy marks recovered after stopped
+	cancel()
+	expect("dead:healthy")
+}
+
+func TestHealthCheck_Custom(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("svc").WithStatus(InstanceStatusDraining))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var healthy atomic.Bool
+	checkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cli.Registry.StartHealthCheck(checkCtx, HealthCheckConfig{
+		Interval: time.Millisecond * 50,
+		Services: []string{"svc"},
+		Check: func(_ context.Context, _ *Instance) error {
+			if healthy.Load() {
+				return nil
+			}
+			return errors.New("unhealthy")
+		},
+	})
+	time.Sleep(time.Millisecond * 200)
+	service, _ := cli.Registry.GetService(ctx, "svc")
+	if s := service.Instances()[0].Status; s != InstanceStatusUnhealthy {
+		t.Fatalf("expect unhealthy, got %s", s)
+	}
+	// status in database restored after recovered
+	healthy.Store(true)
+	time.Sleep(time.Millisecond * 200)
+	if s := service.Instances()[0].Status; s != InstanceStatusDraining {
+		t.Fatalf("expect draining, got %s", s)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
@@ -16,6 +17,14 @@ const (
 	defaultPort              = 8000
 )
 
+// instance status define
+const (
+	InstanceStatusStarting  InstanceStatus = "starting"
+	InstanceStatusServing   InstanceStatus = "serving"
+	InstanceStatusDraining  InstanceStatus = "draining"
+	InstanceStatusUnhealthy InstanceStatus = "unhealthy"
+)
+
 type (
 	// Instance of registry object
 	Instance struct {
@@ -25,12 +34,17 @@ type (
 		Port        int                    `json:"port"`         // port
 		ServiceName string                 `json:"service_name"` // service name, usually use it as routing key
 		Meta        map[string]interface{} `json:"meta"`         // meta data
+		Status      InstanceStatus         `json:"status"`       // status, default serving
+		Region      string                 `json:"region"`       // region, default Config.Locality
+		Zone        string                 `json:"zone"`         // zone, default Config.Locality
 	}
+	// InstanceStatus of instance
+	InstanceStatus string
 	// Service contains instances
 	Service struct {
-		mu        sync.RWMutex
-		Name      string
-		instances []*Instance
+		mu   sync.Mutex // serializes writers, readers load snap without lock
+		Name string
+		snap atomic.Pointer[ServiceSnapshot]
 	}
 )
 
@@ -58,6 +72,16 @@ func (i *Instance) WithMetaData(meta map[string]interface{}) *Instance {
 	return i
 }
 
+func (i *Instance) WithStatus(status InstanceStatus) *Instance {
+	i.Status = status
+	return i
+}
+
+// Serving instance can accept requests, empty status is treated as serving
+func (i *Instance) Serving() bool {
+	return i.Status == "" || i.Status == InstanceStatusServing
+}
+
 // Identity generate identity
 func (i *Instance) Identity(separator ...string) string {
 	sep := defaultIdentitySeparator
@@ -95,13 +119,31 @@ func (i *Instance) clone() *Instance {
 		Port:        i.Port,
 		ServiceName: i.ServiceName,
 		Meta:        meta,
+		Status:      i.Status,
+		Region:      i.Region,
+		Zone:        i.Zone,
 	}
 }
 
-func (i *Instance) fillInfo() *Instance {
+func (i *Instance) WithLocality(region, zone string) *Instance {
+	i.Region = region
+	i.Zone = zone
+	return i
+}
+
+func (i *Instance) fillInfo(locality ...Locality) *Instance {
 	if i.Id == "" {
 		i.Id = uuid.New().String()
 	}
+	// use default locality if not set
+	if len(locality) > 0 {
+		if i.Region == "" {
+			i.Region = locality[0].Region
+		}
+		if i.Zone == "" {
+			i.Zone = locality[0].Zone
+		}
+	}
 	// try fetch host name if not exist
 	if i.HostName == "" {
 		i.HostName, _ = os.Hostname()
@@ -110,6 +152,9 @@ func (i *Instance) fillInfo() *Instance {
 	if i.Port <= 0 || i.Port > 65535 {
 		i.Port = defaultPort
 	}
+	if i.Status == "" {
+		i.Status = InstanceStatusServing
+	}
 	// try to get ip address it host field not set,
 	// if failed to get ipv4 address use hostname as host
 	if i.Host == "" {
@@ -125,9 +170,22 @@ func (i *Instance) fillInfo() *Instance {
 func (s *Service) remove(id string) *Instance {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for i, instance := range s.instances {
+	snap := s.Snapshot()
+	for i, instance := range snap.instances {
+		if instance.Identity() == id {
+			ins := make([]*Instance, 0, len(snap.instances)-1)
+			ins = append(append(ins, snap.instances[:i]...), snap.instances[i+1:]...)
+			s.swap(snap, ins)
+			return instance
+		}
+	}
+	return nil
+}
+
+// get instance by identity, nil if not found
+func (s *Service) get(id string) *Instance {
+	for _, instance := range s.Snapshot().instances {
 		if instance.Identity() == id {
-			s.instances = append(s.instances[:i], s.instances[i+1:]...)
 			return instance
 		}
 	}
@@ -138,46 +196,72 @@ func (s *Service) remove(id string) *Instance {
 func (s *Service) append(instance ...*Instance) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.instances = append(s.instances, instance...)
+	snap := s.Snapshot()
+	ins := make([]*Instance, 0, len(snap.instances)+len(instance))
+	s.swap(snap, append(append(ins, snap.instances...), instance...))
 }
 
 // upsert or insert instance to instances. notice: insertion not in order
 func (s *Service) upsert(instance *Instance) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	for i, ori := range s.instances {
+	snap := s.Snapshot()
+	ins := make([]*Instance, len(snap.instances), len(snap.instances)+1)
+	copy(ins, snap.instances)
+	for i, ori := range ins {
 		if ori.Identity() == instance.Identity() {
-			s.instances[i] = instance
+			ins[i] = instance
+			s.swap(snap, ins)
 			return
 		}
 	}
-	s.instances = append(s.instances, instance)
+	s.swap(snap, append(ins, instance))
+}
+
+// swap snapshot with new instances, caller must hold mu
+func (s *Service) swap(cur *ServiceSnapshot, instances []*Instance) {
+	s.snap.Store(newServiceSnapshot(cur.version+1, instances))
+}
+
+// Snapshot of current instances, lock free and never modified after created
+func (s *Service) Snapshot() *ServiceSnapshot {
+	if snap := s.snap.Load(); snap != nil {
+		return snap
+	}
+	return emptyServiceSnapshot
+}
+
+// Version of instances, increased on every change
+func (s *Service) Version() uint64 {
+	return s.Snapshot().version
+}
+
+// ChangedSince returns true if instances changed since version
+func (s *Service) ChangedSince(version uint64) bool {
+	return s.Snapshot().version != version
 }
 
 // Range instances
 func (s *Service) Range(h func(instance *Instance) bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for _, instance := range s.instances {
-		if !h(instance) {
-			break
-		}
-	}
+	s.Snapshot().Range(h)
 }
 
 // Len of instance
 func (s *Service) Len() int {
-	return len(s.instances)
+	return s.Snapshot().Len()
 }
 
 // Instances slice copy of this service
 func (s *Service) Instances() []*Instance {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	var ins []*Instance
-	for _, instance := range s.instances {
-		ins = append(ins, instance.clone())
-	}
-	return ins
+	return s.Snapshot().Instances()
+}
+
+// ServingInstances slice copy of serving instances of this service
+func (s *Service) ServingInstances() []*Instance {
+	return s.Snapshot().ServingInstances()
+}
+
+// RangeServing serving instances
+func (s *Service) RangeServing(h func(instance *Instance) bool) {
+	s.Snapshot().RangeServing(h)
 }
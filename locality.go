@@ -0,0 +1,39 @@
+package simple_registry
+
+func (r *registry) locality() Locality {
+	return r.cfg.Locality
+}
+
+// Locality of instance
+func (i *Instance) Locality() Locality {
+	return Locality{Region: i.Region, Zone: i.Zone}
+}
+
+// preferLocality returns instances in same zone, spill over to same region and
+// then all instances if local pool less than min
+func preferLocality(instances []*Instance, local Locality, min int) []*Instance {
+	if local.Zone != "" {
+		if zone := filterInstances(instances, func(ins *Instance) bool {
+			return ins.Zone == local.Zone && (local.Region == "" || ins.Region == local.Region)
+		}); len(zone) >= min {
+			return zone
+		}
+	}
+	if local.Region != "" {
+		if region := filterInstances(instances, func(ins *Instance) bool {
+			return ins.Region == local.Region
+		}); len(region) >= min {
+			return region
+		}
+	}
+	return instances
+}
+
+func filterInstances(instances []*Instance, f func(ins *Instance) bool) (res []*Instance) {
+	for _, ins := range instances {
+		if f(ins) {
+			res = append(res, ins)
+		}
+	}
+	return
+}
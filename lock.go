@@ -0,0 +1,254 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLockTTL           = 10
+	defaultLockRetryInterval = time.Second
+)
+
+var (
+	ErrLockHeld = errors.New("lock held by others")
+)
+
+type (
+	// Lock distributed lock acquired by Lock or TryLock of Storages
+	Lock interface {
+		// Token fencing token increased on every acquisition, resources guarded by
+		// lock should reject requests with token less than the latest one seen
+		Token() int64
+		// Lost closed if lock lost by lease expiry or deletion, not confirmed held within ttl, or released
+		Lost() <-chan struct{}
+		// Unlock release lock if still held
+		Unlock(ctx context.Context) (err error)
+	}
+	// LockConfig of lock
+	LockConfig struct {
+		TTL           int64         `json:"ttl"`            // ttl of lease in second, kept alive until released, default 10
+		RetryInterval time.Duration `json:"retry_interval"` // Lock tries again after it in case of release event lost, default 1s
+	}
+
+	lock struct {
+		db          Database
+		key         string
+		owner       string // unique value of lock key
+		token       int64
+		ttl         time.Duration
+		lost        chan struct{}
+		lostOnce    sync.Once
+		releaseOnce sync.Once
+		releaseErr  error
+		cancel      context.CancelFunc
+	}
+)
+
+func (c *LockConfig) check() {
+	if c.TTL <= 0 {
+		c.TTL = defaultLockTTL
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultLockRetryInterval
+	}
+}
+
+// Lock blocks until lock acquired or context done. lock is released when context done.
+// ErrNotSupported if compare and set of database is not atomic across processes, e.g. nacos
+func (s *storages) Lock(ctx context.Context, name string, config ...LockConfig) (l Lock, err error) {
+	if !atomicCAS(s.db) {
+		return nil, ErrNotSupported
+	}
+	cfg := LockConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.check()
+
+	// watch before trying, so that release between them won't be missed
+	released := make(chan struct{}, 1)
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	key := s.cfg.getLockPrefix() + name
+	if err = s.db.Watch(wctx, key, func(_ context.Context, e Event) {
+		// some databases watch key as prefix
+		if e.Key == key && e.Type == EventTypeDelete {
+			select {
+			case released <- struct{}{}:
+			default:
+			}
+		}
+	}); err != nil {
+		return
+	}
+	for {
+		if l, err = s.TryLock(ctx, name, cfg); !errors.Is(err, ErrLockHeld) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		case <-time.After(cfg.RetryInterval):
+		}
+	}
+}
+
+// TryLock acquire lock or returns ErrLockHeld immediately. lock is released when context done.
+// ErrNotSupported if compare and set of database is not atomic across processes, e.g. nacos
+func (s *storages) TryLock(ctx context.Context, name string, config ...LockConfig) (l Lock, err error) {
+	if !atomicCAS(s.db) {
+		return nil, ErrNotSupported
+	}
+	cfg := LockConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.check()
+
+	lk := &lock{
+		db:    s.db,
+		key:   s.cfg.getLockPrefix() + name,
+		owner: uuid.New().String(),
+		ttl:   time.Duration(cfg.TTL) * time.Second,
+		lost:  make(chan struct{}),
+	}
+	// lease is kept alive until lock context done
+	var lctx context.Context
+	lctx, lk.cancel = context.WithCancel(ctx)
+	if err = s.db.Create(lctx, lk.key, lk.owner, cfg.TTL, true); err != nil {
+		lk.cancel()
+		if errors.Is(err, ErrConflict) {
+			err = fmt.Errorf("%w: %s", ErrLockHeld, name)
+		}
+		return
+	}
+	go func() {
+		<-lctx.Done()
+		_ = lk.release(context.WithoutCancel(ctx))
+	}()
+
+	if lk.token, err = s.fencingToken(lctx, name, lk.key); err == nil {
+		err = lk.watch(lctx)
+	}
+	if err != nil {
+		_ = lk.Unlock(context.WithoutCancel(ctx))
+		return
+	}
+	go lk.confirm(lctx)
+	return lk, nil
+}
+
+// fencingToken is create revision of lock key, or counter increased by
+// compare and set if revision not supported by database
+func (s *storages) fencingToken(ctx context.Context, name, key string) (token int64, err error) {
+	kvs, err := s.db.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if len(kvs) > 0 && kvs[0].CreateRevision > 0 {
+		return kvs[0].CreateRevision, nil
+	}
+
+	counter := s.cfg.getLockTokenPrefix() + name
+	for {
+		if kvs, err = s.db.Get(ctx, counter); err != nil {
+			return
+		}
+		cmp := CompareRevision(0)
+		token = 1
+		if len(kvs) > 0 {
+			cmp = CompareValue(kvs[0].Value.String())
+			token = kvs[0].Value.Int64() + 1
+		}
+		if err = s.db.CompareAndSet(ctx, counter, cmp, token); !errors.Is(err, ErrConflict) {
+			return
+		}
+	}
+}
+
+// watch lock key and mark lost if deleted or changed
+func (l *lock) watch(ctx context.Context) (err error) {
+	if err = l.db.Watch(ctx, l.key, func(_ context.Context, e Event) {
+		if e.Key == l.key && (e.Type == EventTypeDelete || e.Value.String() != l.owner) {
+			l.setLost()
+		}
+	}); err != nil {
+		return
+	}
+	// check again in case lost before watching
+	kvs, err := l.db.Get(ctx, l.key)
+	if err == nil && (len(kvs) == 0 || kvs[0].Value.String() != l.owner) {
+		l.setLost()
+	}
+	return
+}
+
+// confirm lock held periodically and mark lost if not confirmed within ttl,
+// lease may be expired in database while keepalive failed, e.g. partitioned from database
+func (l *lock) confirm(ctx context.Context) {
+	deadline := time.AfterFunc(l.ttl, l.setLost)
+	defer deadline.Stop()
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		kvs, err := l.db.Get(ctx, l.key)
+		if err != nil {
+			continue
+		}
+		if len(kvs) == 0 || kvs[0].Value.String() != l.owner {
+			l.setLost()
+			return
+		}
+		// held at start at least, already lost if fired
+		if !deadline.Stop() {
+			return
+		}
+		deadline.Reset(time.Until(start.Add(l.ttl)))
+	}
+}
+
+func (l *lock) Token() int64 {
+	return l.token
+}
+
+func (l *lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *lock) Unlock(ctx context.Context) (err error) {
+	l.cancel()
+	return l.release(ctx)
+}
+
+// release deletes lock key if still held and marks lost
+func (l *lock) release(ctx context.Context) error {
+	l.releaseOnce.Do(func() {
+		_, err := l.db.Txn(ctx, []Guard{{Key: l.key, Compare: CompareValue(l.owner)}}, OpDelete(l.key))
+		// already lost
+		if !errors.Is(err, ErrConflict) {
+			l.releaseErr = err
+		}
+		l.setLost()
+	})
+	return l.releaseErr
+}
+
+func (l *lock) setLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+		l.cancel()
+	})
+}
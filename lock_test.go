@@ -0,0 +1,120 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}}
+	cli1, err := New(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli2, err := New(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l1, err := cli1.Storages.TryLock(ctx, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli2.Storages.TryLock(ctx, "job"); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expect lock held, got %v", err)
+	}
+
+	// blocked until released
+	acquired := make(chan Lock)
+	lockCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		l, err := cli2.Storages.Lock(lockCtx, "job", LockConfig{RetryInterval: time.Minute})
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- l
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	case <-time.After(time.Millisecond * 100):
+	}
+	if err = l1.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-l1.Lost()
+	var l2 Lock
+	select {
+	case l2 = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("wait lock timeout")
+	}
+	if l2.Token() <= l1.Token() {
+		t.Fatalf("fencing token not increased: %d <= %d", l2.Token(), l1.Token())
+	}
+
+	// released on context cancel
+	cancel()
+	select {
+	case <-l2.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lock not released on context cancel")
+	}
+	l3, err := cli1.Storages.TryLock(ctx, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lost if lease expired
+	if err = cli1.Storages.db.Revoke(ctx, cli1.Storages.cfg.getLockPrefix()+"job"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l3.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lost not notified")
+	}
+	if err = l3.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unconfirmed(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &flakyGetDB{Database: cli.Storages.db}
+	s := &storages{cfg: cli.Storages.cfg, db: db}
+	l, err := s.TryLock(ctx, "job", LockConfig{TTL: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// held while confirmed
+	select {
+	case <-l.Lost():
+		t.Fatal("lost while confirmed")
+	case <-time.After(time.Millisecond * 1500):
+	}
+	// lost if not confirmed within ttl, e.g. partitioned from database
+	db.fails.Store(1 << 30)
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second * 2):
+		t.Fatal("lost not notified")
+	}
+}
+
+func TestLock_NotSupported(t *testing.T) {
+	s := &storages{db: &nacos{}}
+	if _, err := s.TryLock(context.Background(), "job"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+	if _, err := s.Lock(context.Background(), "job"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expect not supported, got %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+const (
+	defaultWeightKey = "weight"
+	defaultReplicas  = 100
+
+	defaultMinZoneInstances = 1
+)
+
+// picker strategy define
+const (
+	PickerRoundRobin         PickerStrategy = "round_robin"
+	PickerRandom             PickerStrategy = "random"
+	PickerWeighted           PickerStrategy = "weighted"
+	PickerLeastRecentlyUsed  PickerStrategy = "least_recently_used"
+	PickerConsistentHash     PickerStrategy = "consistent_hash"
+	defaultPickerStrategy                   = PickerRoundRobin
+	consistentHashNodeFormat                = "%s#%d"
+)
+
+var (
+	ErrNoAvailableInstance = errors.New("no available instance")
+	ErrPickKeyRequired     = errors.New("pick key required")
+)
+
+type (
+	// Picker picks an instance of service from local cache
+	Picker interface {
+		// Pick an instance, key is required by consistent hash picker
+		Pick(ctx context.Context, key ...string) (ins *Instance, err error)
+	}
+	// PickerStrategy of load balance
+	PickerStrategy string
+	// PickerConfig for picker
+	PickerConfig struct {
+		Strategy  PickerStrategy `json:"strategy"`   // default round_robin
+		WeightKey string         `json:"weight_key"` // meta key of weight in weighted picker, default "weight"
+		Replicas  int            `json:"replicas"`   // virtual nodes of each instance in consistent hash picker, default 100
+		// prefer instances in same zone, then same region, spill over to others
+		// if less than MinZoneInstances instances available in local pool
+		ZoneAware        bool   `json:"zone_aware"`
+		Region           string `json:"region"`             // local region, default Config.Locality of registry
+		Zone             string `json:"zone"`               // local zone, default Config.Locality of registry
+		MinZoneInstances int    `json:"min_zone_instances"` // default 1
+	}
+
+	picker struct {
+		reg       Interface
+		name      string
+		cfg       PickerConfig
+		mu        sync.Mutex
+		service   *Service
+		version   uint64
+		instances []*Instance
+		b         balancer
+	}
+	// balancer picks from instances, update is called when instances changed
+	balancer interface {
+		update(instances []*Instance)
+		pick(instances []*Instance, key string) (*Instance, error)
+	}
+	roundRobinBalancer struct {
+		next int
+	}
+	randomBalancer   struct{}
+	weightedBalancer struct {
+		key     string
+		weights []int
+		total   int
+	}
+	lruBalancer struct {
+		seq  uint64
+		used map[string]uint64 // identity : sequence of last used
+	}
+	consistentHashBalancer struct {
+		replicas int
+		ring     []uint32
+		nodes    map[uint32]*Instance
+	}
+)
+
+func (c *PickerConfig) check() {
+	if c.Strategy == "" {
+		c.Strategy = defaultPickerStrategy
+	}
+	if c.WeightKey == "" {
+		c.WeightKey = defaultWeightKey
+	}
+	if c.Replicas <= 0 {
+		c.Replicas = defaultReplicas
+	}
+	if c.MinZoneInstances <= 0 {
+		c.MinZoneInstances = defaultMinZoneInstances
+	}
+}
+
+// NewPicker of service, serving instances are read from local cache of registry and
+// picker state is rebuilt when instances changed.
+func NewPicker(reg Interface, serviceName string, config ...PickerConfig) (p Picker, err error) {
+	cfg := PickerConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.check()
+	if cfg.ZoneAware {
+		locality := reg.locality()
+		if cfg.Region == "" {
+			cfg.Region = locality.Region
+		}
+		if cfg.Zone == "" {
+			cfg.Zone = locality.Zone
+		}
+	}
+
+	var b balancer
+	switch cfg.Strategy {
+	case PickerRoundRobin:
+		b = &roundRobinBalancer{}
+	case PickerRandom:
+		b = &randomBalancer{}
+	case PickerWeighted:
+		b = &weightedBalancer{key: cfg.WeightKey}
+	case PickerLeastRecentlyUsed:
+		b = &lruBalancer{used: make(map[string]uint64)}
+	case PickerConsistentHash:
+		b = &consistentHashBalancer{replicas: cfg.Replicas}
+	default:
+		err = fmt.Errorf("unknown picker strategy \"%s\"", cfg.Strategy)
+		return
+	}
+	p = &picker{reg: reg, name: serviceName, cfg: cfg, b: b}
+	return
+}
+
+func (p *picker) Pick(ctx context.Context, key ...string) (ins *Instance, err error) {
+	service, err := p.reg.GetService(ctx, p.name)
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if service != p.service || service.ChangedSince(p.version) {
+		snap := service.Snapshot()
+		p.instances, p.version = snap.ServingInstances(), snap.Version()
+		p.service = service
+		if p.cfg.ZoneAware {
+			p.instances = preferLocality(p.instances, Locality{Region: p.cfg.Region, Zone: p.cfg.Zone}, p.cfg.MinZoneInstances)
+		}
+		p.b.update(p.instances)
+	}
+	if len(p.instances) == 0 {
+		return nil, ErrNoAvailableInstance
+	}
+
+	k := ""
+	if len(key) > 0 {
+		k = key[0]
+	}
+	if ins, err = p.b.pick(p.instances, k); err != nil {
+		return
+	}
+	return ins.clone(), nil
+}
+
+func (b *roundRobinBalancer) update(_ []*Instance) {}
+
+func (b *roundRobinBalancer) pick(instances []*Instance, _ string) (*Instance, error) {
+	ins := instances[b.next%len(instances)]
+	b.next = (b.next + 1) % len(instances)
+	return ins, nil
+}
+
+func (b *randomBalancer) update(_ []*Instance) {}
+
+func (b *randomBalancer) pick(instances []*Instance, _ string) (*Instance, error) {
+	return instances[rand.Intn(len(instances))], nil
+}
+
+// update weights, instance without weight in meta has weight 1, non-positive weight never be picked
+func (b *weightedBalancer) update(instances []*Instance) {
+	b.weights = make([]int, len(instances))
+	b.total = 0
+	for i, instance := range instances {
+		weight := 1
+		if v, ok := instance.Meta[b.key]; ok {
+			weight = gconv.Int(v)
+		}
+		if weight < 0 {
+			weight = 0
+		}
+		b.weights[i] = weight
+		b.total += weight
+	}
+}
+
+func (b *weightedBalancer) pick(instances []*Instance, _ string) (*Instance, error) {
+	if b.total <= 0 {
+		return nil, ErrNoAvailableInstance
+	}
+	n := rand.Intn(b.total)
+	for i, weight := range b.weights {
+		if n < weight {
+			return instances[i], nil
+		}
+		n -= weight
+	}
+	return nil, ErrNoAvailableInstance
+}
+
+// update drop usage of removed instances
+func (b *lruBalancer) update(instances []*Instance) {
+	used := make(map[string]uint64, len(instances))
+	for _, instance := range instances {
+		id := instance.Identity()
+		used[id] = b.used[id]
+	}
+	b.used = used
+}
+
+func (b *lruBalancer) pick(instances []*Instance, _ string) (*Instance, error) {
+	var target *Instance
+	for _, instance := range instances {
+		if target == nil || b.used[instance.Identity()] < b.used[target.Identity()] {
+			target = instance
+		}
+	}
+	b.seq++
+	b.used[target.Identity()] = b.seq
+	return target, nil
+}
+
+// update rebuild hash ring with virtual nodes
+func (b *consistentHashBalancer) update(instances []*Instance) {
+	b.ring = make([]uint32, 0, len(instances)*b.replicas)
+	b.nodes = make(map[uint32]*Instance, len(instances)*b.replicas)
+	for _, instance := range instances {
+		for i := 0; i < b.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(fmt.Sprintf(consistentHashNodeFormat, instance.Identity(), i)))
+			if _, ok := b.nodes[h]; ok {
+				continue
+			}
+			b.nodes[h] = instance
+			b.ring = append(b.ring, h)
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+}
+
+func (b *consistentHashBalancer) pick(_ []*Instance, key string) (*Instance, error) {
+	if key == "" {
+		return nil, ErrPickKeyRequired
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= h })
+	if i == len(b.ring) {
+		i = 0
+	}
+	return b.nodes[b.ring[i]], nil
+}
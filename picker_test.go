@@ -0,0 +1,212 @@
+package simple_registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newPickerClient(t *testing.T, weights ...int) (*Client, []Registration) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rgs []Registration
+	for i, weight := range weights {
+		rg, err := cli.Registry.Register(ctx, NewInstance("picker").
+			WithAddress("127.0.0.1", 8080+i).
+			WithMetaData(map[string]interface{}{"weight": weight}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgs = append(rgs, rg)
+	}
+	time.Sleep(time.Millisecond * 100)
+	return cli, rgs
+}
+
+func pickCounts(t *testing.T, p Picker, n int, key ...string) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		ins, err := p.Pick(context.Background(), key...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[ins.Port]++
+	}
+	return counts
+}
+
+func TestPicker_RoundRobin(t *testing.T) {
+	cli, rgs := newPickerClient(t, 1, 1, 1)
+	p, err := NewPicker(cli.Registry, "picker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for port, count := range pickCounts(t, p, 30) {
+		if count != 10 {
+			t.Fatalf("round robin not even: %d picked %d times", port, count)
+		}
+	}
+
+	// removed instance never picked
+	if err = rgs[0].Deregister(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	counts := pickCounts(t, p, 10)
+	if _, ok := counts[8080]; ok || len(counts) != 2 {
+		t.Fatalf("picked removed instance: %v", counts)
+	}
+
+	// draining instance never picked
+	if err = rgs[1].SetStatus(context.Background(), InstanceStatusDraining); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	counts = pickCounts(t, p, 10)
+	if _, ok := counts[8081]; ok || len(counts) != 1 {
+		t.Fatalf("picked draining instance: %v", counts)
+	}
+}
+
+func TestPicker_Weighted(t *testing.T) {
+	cli, _ := newPickerClient(t, 1, 3, 0)
+	p, err := NewPicker(cli.Registry, "picker", PickerConfig{Strategy: PickerWeighted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := pickCounts(t, p, 4000)
+	if counts[8082] != 0 {
+		t.Fatalf("zero weight instance picked: %v", counts)
+	}
+	if ratio := float64(counts[8081]) / float64(counts[8080]); ratio < 2.5 || ratio > 3.5 {
+		t.Fatalf("weighted distribution not match: %v", counts)
+	}
+}
+
+func TestPicker_LeastRecentlyUsed(t *testing.T) {
+	cli, _ := newPickerClient(t, 1, 1)
+	p, err := NewPicker(cli.Registry, "picker", PickerConfig{Strategy: PickerLeastRecentlyUsed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := p.Pick(context.Background())
+	second, _ := p.Pick(context.Background())
+	third, _ := p.Pick(context.Background())
+	if first.Port == second.Port || first.Port != third.Port {
+		t.Fatalf("least recently used not match: %d %d %d", first.Port, second.Port, third.Port)
+	}
+
+	// newly registered instance is never used
+	if _, err = cli.Registry.Register(context.Background(), NewInstance("picker").WithAddress("127.0.0.1", 9000)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if ins, _ := p.Pick(context.Background()); ins.Port != 9000 {
+		t.Fatalf("expect new instance picked, got %d", ins.Port)
+	}
+}
+
+func TestPicker_ConsistentHash(t *testing.T) {
+	cli, rgs := newPickerClient(t, 1, 1, 1)
+	p, err := NewPicker(cli.Registry, "picker", PickerConfig{Strategy: PickerConsistentHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = p.Pick(ctx); err != ErrPickKeyRequired {
+		t.Fatalf("expect pick key required, got %v", err)
+	}
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}
+	before := make(map[string]int)
+	for _, key := range keys {
+		ins, err := p.Pick(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, _ := p.Pick(ctx, key); again.Port != ins.Port {
+			t.Fatalf("same key picked different instances: %d %d", ins.Port, again.Port)
+		}
+		before[key] = ins.Port
+	}
+
+	// only keys of removed instance move
+	removed := rgs[1].Instance().Port
+	if err = rgs[1].Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	for _, key := range keys {
+		ins, _ := p.Pick(ctx, key)
+		if ins.Port == removed || (before[key] != removed && before[key] != ins.Port) {
+			t.Fatalf("key %s moved from %d to %d", key, before[key], ins.Port)
+		}
+	}
+}
+
+func TestPicker_NoInstance(t *testing.T) {
+	cli, _ := newPickerClient(t)
+	p, err := NewPicker(cli.Registry, "picker", PickerConfig{Strategy: PickerRandom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.Pick(context.Background()); err != ErrServiceNotFound {
+		t.Fatalf("expect service not found, got %v", err)
+	}
+	if _, err = NewPicker(cli.Registry, "picker", PickerConfig{Strategy: "unknown"}); err == nil {
+		t.Fatal("expect unknown strategy error")
+	}
+}
+
+func TestPicker_ZoneAware(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{
+		Type:     TypeMemory,
+		Database: DatabaseConfig{Endpoints: []string{t.Name()}},
+		Locality: Locality{Region: "r1", Zone: "z1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rgs []Registration
+	for i, ins := range []*Instance{
+		// default locality of registry
+		NewInstance("picker"),
+		NewInstance("picker").WithLocality("r1", "z2"),
+		NewInstance("picker").WithLocality("r2", "z3"),
+	} {
+		rg, err := cli.Registry.Register(ctx, ins.WithAddress("127.0.0.1", 8080+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rgs = append(rgs, rg)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if ins := rgs[0].Instance(); ins.Region != "r1" || ins.Zone != "z1" {
+		t.Fatalf("locality not filled: %s/%s", ins.Region, ins.Zone)
+	}
+
+	p, err := NewPicker(cli.Registry, "picker", PickerConfig{ZoneAware: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts := pickCounts(t, p, 10); counts[8080] != 10 {
+		t.Fatalf("expect same zone picked: %v", counts)
+	}
+	// spill over to same region
+	p, _ = NewPicker(cli.Registry, "picker", PickerConfig{ZoneAware: true, MinZoneInstances: 2})
+	if counts := pickCounts(t, p, 10); len(counts) != 2 || counts[8082] != 0 {
+		t.Fatalf("expect same region picked: %v", counts)
+	}
+	// spill over to all
+	if err = rgs[0].Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if counts := pickCounts(t, p, 10); len(counts) != 2 || counts[8082] == 0 {
+		t.Fatalf("expect all zones picked: %v", counts)
+	}
+}
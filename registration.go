@@ -0,0 +1,280 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+var (
+	ErrIdentityChanged = errors.New("instance identity changed")
+)
+
+// registration state define
+const (
+	RegistrationStateRegistered   RegistrationState = "registered"
+	RegistrationStateLost         RegistrationState = "lost" // lease lost, registering again
+	RegistrationStateDeregistered RegistrationState = "deregistered"
+)
+
+type (
+	// Registration of instance registered by Interface.Register
+	Registration interface {
+		// Instance copy of registered instance
+		Instance() *Instance
+		// Update registered instance, identity (service name, id, host) can not be changed
+		Update(ctx context.Context, ins *Instance) (err error)
+		// UpdateMeta merge meta into registered instance and keep its lease
+		UpdateMeta(ctx context.Context, meta map[string]interface{}) (err error)
+		// SetStatus of registered instance and keep its lease, e.g. draining before shutdown
+		SetStatus(ctx context.Context, status InstanceStatus) (err error)
+		// Campaign for leader of service with this instance until ctx done, resigned or deregistered.
+		// election in progress is returned if called again. ErrNotSupported by nacos, whose compare and set
+		// is not atomic across processes
+		Campaign(ctx context.Context) (e Election, err error)
+		// Deregister instance, resign election, stop heartbeat and revoke its lease
+		Deregister(ctx context.Context) (err error)
+		// Health state of registration
+		Health() RegistrationState
+	}
+	// RegistrationState of registered instance
+	RegistrationState string
+	// StateHandler of registration state change
+	StateHandler func(i *Instance, s RegistrationState)
+	registration struct {
+		r        *registry
+		mu       sync.Mutex // protect ins, cancel, done and state
+		ins      *Instance
+		cancel   context.CancelFunc // stop heartbeat of current lease
+		done     bool
+		state    RegistrationState
+		stop     context.CancelFunc // stop monitor
+		lost     chan struct{}      // notify monitor key deleted
+		election *election          // election campaigning, nil if not
+	}
+)
+
+func newRegistration(r *registry, ins *Instance) *registration {
+	return &registration{r: r, ins: ins, lost: make(chan struct{}, 1)}
+}
+
+func (rg *registration) Instance() *Instance {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	return rg.ins.clone()
+}
+
+func (rg *registration) Update(ctx context.Context, ins *Instance) (err error) {
+	rg.mu.Lock()
+	if rg.done {
+		rg.mu.Unlock()
+		return ErrNotRegistered
+	}
+	ins = ins.clone()
+	if ins.Id == "" {
+		ins.Id = rg.ins.Id
+	}
+	if ins.Host == "" {
+		ins.Host = rg.ins.Host
+	}
+	if ins.Status == "" {
+		ins.Status = rg.ins.Status
+	}
+	if ins.Identity() != rg.ins.Identity() {
+		rg.mu.Unlock()
+		return ErrIdentityChanged
+	}
+	rg.ins = ins.fillInfo(rg.r.cfg.Locality)
+	rg.mu.Unlock()
+
+	if err = rg.put(ctx); err != nil {
+		return
+	}
+	g.Log().Infof(ctx, "registry update success: %s", ins.String())
+	return
+}
+
+func (rg *registration) UpdateMeta(ctx context.Context, meta map[string]interface{}) (err error) {
+	ins, err := rg.modify(ctx, func(ins *Instance) { ins.WithMetaData(meta) })
+	if err != nil {
+		return
+	}
+	g.Log().Infof(ctx, "registry update meta success: %s", ins.String())
+	return
+}
+
+func (rg *registration) SetStatus(ctx context.Context, status InstanceStatus) (err error) {
+	ins, err := rg.modify(ctx, func(ins *Instance) { ins.Status = status })
+	if err != nil {
+		return
+	}
+	g.Log().Infof(ctx, "registry set status success: %s", ins.String())
+	return
+}
+
+// modify copy of registered instance and write it under existing lease
+func (rg *registration) modify(ctx context.Context, f func(ins *Instance)) (ins *Instance, err error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.done {
+		return nil, ErrNotRegistered
+	}
+
+	r := rg.r
+	ins = rg.ins.clone()
+	f(ins)
+	if err = r.cli.Update(ctx, ins.registryIdentity(r.cfg.getRegistryPrefix()), ins.String()); err != nil {
+		return
+	}
+	rg.ins = ins
+	return
+}
+
+func (rg *registration) Deregister(ctx context.Context) (err error) {
+	rg.mu.Lock()
+	if rg.done {
+		rg.mu.Unlock()
+		return
+	}
+	rg.done = true
+	ins, cancel, stop, el := rg.ins, rg.cancel, rg.stop, rg.election
+	rg.cancel = nil
+	rg.mu.Unlock()
+
+	if el != nil {
+		if err = el.Resign(ctx); err != nil {
+			g.Log().Warningf(ctx, "registry failed to resign %s: %v", el.key, err)
+		}
+	}
+
+	// stop monitor and heartbeat
+	if stop != nil {
+		stop()
+	}
+	if cancel != nil {
+		cancel()
+	}
+	rg.setState(RegistrationStateDeregistered)
+
+	r := rg.r
+	r.mu.Lock()
+	delete(r.registrations, ins.Identity())
+	if r.current == rg {
+		r.current = nil
+	}
+	r.mu.Unlock()
+
+	err = r.cli.Revoke(ctx, ins.registryIdentity(r.cfg.getRegistryPrefix()))
+	return
+}
+
+func (rg *registration) Health() RegistrationState {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	return rg.state
+}
+
+// put instance with a new lease and heartbeat, previous heartbeat stops after success
+func (rg *registration) put(ctx context.Context) (err error) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	if rg.done {
+		return ErrNotRegistered
+	}
+
+	r := rg.r
+	// renew a context in case upstream context closed cause heartbeat timeout
+	hbCtx, cancel := context.WithCancel(context.Background())
+	if err = r.cli.Set(hbCtx,
+		rg.ins.registryIdentity(r.cfg.getRegistryPrefix()),
+		rg.ins.String(),
+		r.cfg.HeartBeatInterval, true); err != nil {
+		cancel()
+		return
+	}
+	if rg.cancel != nil {
+		rg.cancel()
+	}
+	rg.cancel = cancel
+	return
+}
+
+// set instance from watch event
+func (rg *registration) set(ins *Instance) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.ins = ins
+}
+
+// monitor detects lease loss by delete event or periodic check and registers again,
+// it stops when Deregister called.
+func (rg *registration) monitor() {
+	ctx, stop := context.WithCancel(context.Background())
+	rg.mu.Lock()
+	rg.stop = stop
+	rg.mu.Unlock()
+
+	ticker := time.NewTicker(time.Duration(rg.r.cfg.HeartBeatInterval) * time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-rg.lost:
+			case <-ticker.C:
+			}
+			rg.check(ctx)
+		}
+	}()
+}
+
+// check registered key exists, put again if lost
+func (rg *registration) check(ctx context.Context) {
+	r := rg.r
+	ins := rg.Instance()
+	kvs, err := r.cli.Get(ctx, ins.registryIdentity(r.cfg.getRegistryPrefix()))
+	if err != nil {
+		// database unreachable, check again later
+		g.Log().Warningf(ctx, "registry failed to check registration %s: %v", ins.Identity(), err)
+		return
+	}
+	if len(kvs) > 0 {
+		return
+	}
+
+	g.Log().Warningf(ctx, "registry lease lost, register again: %s", ins.Identity())
+	rg.setState(RegistrationStateLost)
+	if err = rg.put(ctx); err != nil {
+		if !errors.Is(err, ErrNotRegistered) {
+			g.Log().Errorf(ctx, "registry failed to register again %s: %v", ins.Identity(), err)
+		}
+		return
+	}
+	rg.setState(RegistrationStateRegistered)
+}
+
+// notifyLost wakes up monitor to check
+func (rg *registration) notifyLost() {
+	select {
+	case rg.lost <- struct{}{}:
+	default:
+	}
+}
+
+// setState and push to state handlers if changed, deregistered is final
+func (rg *registration) setState(state RegistrationState) {
+	rg.mu.Lock()
+	if rg.state == state || (rg.state == RegistrationStateDeregistered) {
+		rg.mu.Unlock()
+		return
+	}
+	rg.state = state
+	ins := rg.ins.clone()
+	rg.mu.Unlock()
+
+	rg.r.pushState(ins, state)
+}
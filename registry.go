@@ -3,9 +3,9 @@ package simple_registry
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gogf/gf/v2/frame/g"
 )
@@ -16,15 +16,17 @@ var (
 	Registry Interface
 	// Storages Global instance of storages
 	Storages *storages
-	// currentInstance created at Init
-	currentInstance *Instance
-	onceLoad        = sync.Once{}
+	// initMu protect Init
+	initMu = sync.Mutex{}
+	// global client of Registry and Storages, closed by Shutdown
+	global *Client
 )
 
 // error define
 var (
 	ErrAlreadyRegistered = errors.New("already registered")
 	ErrServiceNotFound   = errors.New("service not found")
+	ErrNotRegistered     = errors.New("not registered")
 )
 
 // event type define
@@ -32,112 +34,200 @@ const (
 	EventTypeCreate EventType = "create"
 	EventTypeUpdate EventType = "upsert"
 	EventTypeDelete EventType = "delete"
+	// EventTypeResync changes may be lost (e.g. etcd revision compacted), everything under key should be reloaded
+	EventTypeResync EventType = "resync"
 )
 
+// resyncRetryInterval wait before retry failed resync
+const resyncRetryInterval = time.Second
+
 type (
 	// Interface abstracts registry
 	Interface interface {
-		// register currentInstance
+		// register current instance
 		register(ctx context.Context, ins *Instance) (err error)
-		// Deregister deregister currentInstance
+		// locality of registry config
+		locality() Locality
+		// Register instance with its own heartbeat, multiple instances can be registered in one process
+		Register(ctx context.Context, ins *Instance) (reg Registration, err error)
+		// Deregister deregister current instance
 		Deregister(ctx context.Context) (err error)
+		// UpdateMeta merge meta into current instance and keep its lease, watchers receive an update event
+		UpdateMeta(ctx context.Context, meta map[string]interface{}) (err error)
+		// SetStatus of current instance and keep its lease, watchers receive an update event
+		SetStatus(ctx context.Context, status InstanceStatus) (err error)
+		// Campaign for leader of service with current instance, see Registration.Campaign
+		Campaign(ctx context.Context) (e Election, err error)
 		// GetService by service name
 		GetService(ctx context.Context, serviceName ...string) (service *Service, err error)
 		// GetServices of all
 		GetServices(ctx context.Context) (services map[string]*Service, err error)
-		// RegisterEventHandler register event handler
-		RegisterEventHandler(handler EventHandler)
+		// Select instances matched selector from local cache, all services if serviceName not provided,
+		// ErrServiceNotFound if any of serviceName not found
+		Select(ctx context.Context, selector Selector, serviceName ...string) (instances []*Instance, err error)
+		// RegisterEventHandler register event handler, events are delivered in order by its own goroutine,
+		// Config.Delivery is used if config not provided. call unsubscribe to unregister
+		RegisterEventHandler(handler EventHandler, config ...DeliveryConfig) (unsubscribe func())
+		// RegisterServiceEventHandler register event handler of instances of service, see RegisterEventHandler
+		RegisterServiceEventHandler(serviceName string, handler EventHandler, config ...DeliveryConfig) (unsubscribe func())
+		// RegisterFilteredEventHandler register event handler of instances matched filter, see RegisterEventHandler
+		RegisterFilteredEventHandler(filter EventFilter, handler EventHandler, config ...DeliveryConfig) (unsubscribe func())
+		// Health state of current instance registration, deregistered if not registered
+		Health() RegistrationState
+		// RegisterStateHandler register handler of registration state change, e.g. lease lost and registered again.
+		// handlers are called in order and should not block
+		RegisterStateHandler(handler StateHandler)
+		// RegisterResyncHandler register handler called after local cache resynced in case of watch events lost,
+		// differences found are pushed to event handlers before. call unsubscribe to unregister
+		RegisterResyncHandler(handler ResyncHandler) (unsubscribe func())
+		// Shutdown mark registered instances draining, wait drain period for peers' caches to update,
+		// then deregister them and stop watching
+		Shutdown(ctx context.Context, drain time.Duration) (err error)
+		// StartHealthCheck probe cached instances periodically until ctx done, failing instances are marked
+		// unhealthy in local cache only and EventTypeUnhealthy pushed, EventTypeHealthy pushed after recovered
+		StartHealthCheck(ctx context.Context, config ...HealthCheckConfig)
+		// Watch instance changes of services, all services if serviceName not provided.
+		// events are delivered in order, channel is closed when ctx done
+		Watch(ctx context.Context, serviceName ...string) <-chan ServiceEvent
+		// WatchWithFilter watch instance changes matched filter, see Watch
+		WatchWithFilter(ctx context.Context, filter EventFilter) <-chan ServiceEvent
 	}
 
 	// EventType of instance change
 	EventType string
 	// EventHandler of instance change
 	EventHandler func(i *Instance, e EventType)
-	// eventWrapper ...
-	eventWrapper struct {
-		handler EventHandler
-		next    *eventWrapper
-	}
+	// ResyncHandler called after local cache resynced with database
+	ResyncHandler func()
 )
 
-// Init registry module with config and sync services info from database and build local caches.
-// if *Instance is provided will be register automatically.
-// if context is done, watch loop will stop and local cache won't be updated anymore.
+// Init global Registry and Storages, see New.
+// Init only takes effect once, and can be retried if failed.
 func Init(ctx context.Context, config Config, ins ...*Instance) (err error) {
-	onceLoad.Do(func() {
-		var db Database
-		config.check()
-		switch config.Type {
-		case TypeEtcd:
-			db, err = newEtcd(ctx, config.Database)
-		default:
-			err = fmt.Errorf("unknown registry type \"%s\"", config.Type)
-		}
-		if err != nil {
-			return
-		}
+	initMu.Lock()
+	defer initMu.Unlock()
+	if Registry != nil {
+		return
+	}
 
-		// create registry instance
-		if Registry, err = newRegistry(ctx, config, db); err != nil {
-			return
-		}
-		// collect instance info and register
-		if len(ins) > 0 && ins[0] != nil {
-			err = Registry.register(ctx, ins[0].fillInfo().clone())
-		}
-		// create Storages instance
-		Storages = newStorages(ctx, config, db)
-	})
+	cli, err := New(ctx, config, ins...)
+	if err != nil {
+		return
+	}
+	global, Registry, Storages = cli, cli.Registry, cli.Storages
+	return
+}
+
+// Shutdown global Registry and Storages, see Client.Shutdown. Init can be called again after shutdown
+func Shutdown(ctx context.Context, drain time.Duration) (err error) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if global == nil {
+		return
+	}
+	err = global.Shutdown(ctx, drain)
+	global, Registry, Storages = nil, nil, nil
 	return
 }
 
 type registry struct {
-	cli   Database
-	cfg   *Config
-	cache sync.Map // service_name : *Service
-	evs   *eventWrapper
+	cli           Database
+	cfg           *Config
+	cache         sync.Map                 // service_name : *Service
+	mu            sync.RWMutex             // protect current, registrations and states
+	current       *registration            // registered by Init or New
+	registrations map[string]*registration // identity : *registration
+	states        []StateHandler
+	resyncs       []*ResyncHandler
+	stop          context.CancelFunc // stop watching
+	healthMu      sync.Mutex         // protect unhealthy marks and cached status
+	unhealthy     sync.Map           // identity : InstanceStatus in database, marked unhealthy by health check
+	subsMu        sync.RWMutex
+	subs          map[*subscriber]struct{}
 }
 
-func newRegistry(ctx context.Context, cfg Config, db Database) (r Interface, err error) {
-	reg := &registry{cfg: &cfg, cli: db}
+func newRegistry(ctx context.Context, cfg Config, db Database) (r *registry, err error) {
+	reg := &registry{
+		cfg:           &cfg,
+		cli:           db,
+		registrations: make(map[string]*registration),
+		subs:          make(map[*subscriber]struct{}),
+	}
+	ctx, reg.stop = context.WithCancel(ctx)
 	// build local cache
 	reg.buildCache(ctx)
 	// watchAndUpdateCache changes and upsert local cache
 	// ** notice if context.Done() watchAndUpdateCache loop will stop
-	go reg.watchAndUpdateCache(ctx)
+	reg.watchAndUpdateCache(ctx)
 
 	return reg, nil
 }
 
 func (r *registry) register(ctx context.Context, ins *Instance) (err error) {
 	// check is already registered
-	if currentInstance != nil {
+	if r.currentInstance() != nil {
 		return ErrAlreadyRegistered
 	}
-	currentInstance = ins
 
+	reg, err := r.Register(ctx, ins)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current != nil {
+		// registered concurrently, keep the first one as current
+		return
+	}
+	r.current = reg.(*registration)
+	return
+}
+
+func (r *registry) Register(ctx context.Context, ins *Instance) (reg Registration, err error) {
+	ins = ins.clone().fillInfo(r.cfg.Locality)
+	identity := ins.Identity()
+
+	r.mu.Lock()
+	if _, ok := r.registrations[identity]; ok {
+		r.mu.Unlock()
+		return nil, ErrAlreadyRegistered
+	}
+	rg := newRegistration(r, ins)
+	r.registrations[identity] = rg
+	r.mu.Unlock()
+
+	if err = r.doRegister(ctx, rg); err != nil {
+		r.mu.Lock()
+		delete(r.registrations, identity)
+		r.mu.Unlock()
+		return
+	}
+	rg.setState(RegistrationStateRegistered)
+	rg.monitor()
+	return rg, nil
+}
+
+func (r *registry) doRegister(ctx context.Context, rg *registration) (err error) {
+	ins := rg.ins
 	// get or create service
-	service, err := r.getOrCreateService(ctx, currentInstance.ServiceName)
+	service, err := r.getOrCreateService(ctx, ins.ServiceName)
 	if err != nil {
 		return
 	} else {
 		// check if already registered
-		for _, instance := range service.instances {
-			if instance.Identity() == currentInstance.Identity() {
+		for _, instance := range service.Instances() {
+			if instance.Identity() == ins.Identity() {
 				return ErrAlreadyRegistered
 			}
 		}
 	}
 
 	// register with heartbeat
-	// renew a context in case upstream context closed cause heartbeat timeout
-	if err = r.cli.Set(context.Background(),
-		currentInstance.registryIdentity(r.cfg.getRegistryPrefix()),
-		currentInstance.String(),
-		r.cfg.HeartBeatInterval, true); err != nil {
+	if err = rg.put(ctx); err != nil {
 		return
 	}
-	g.Log().Infof(ctx, "registry success: %s", currentInstance.String())
+	g.Log().Infof(ctx, "registry success: %s", ins.String())
 
 	// rebuild local cache
 	r.buildCache(ctx)
@@ -145,17 +235,51 @@ func (r *registry) register(ctx context.Context, ins *Instance) (err error) {
 }
 
 func (r *registry) Deregister(ctx context.Context) (err error) {
-	if currentInstance == nil {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
 		return
 	}
-	err = r.cli.Delete(ctx, currentInstance.registryIdentity(r.cfg.getRegistryPrefix()))
-	return
+	return current.Deregister(ctx)
+}
+
+func (r *registry) UpdateMeta(ctx context.Context, meta map[string]interface{}) (err error) {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
+		return ErrNotRegistered
+	}
+	return current.UpdateMeta(ctx, meta)
+}
+
+func (r *registry) SetStatus(ctx context.Context, status InstanceStatus) (err error) {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
+		return ErrNotRegistered
+	}
+	return current.SetStatus(ctx, status)
+}
+
+func (r *registry) Campaign(ctx context.Context) (e Election, err error) {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
+		return nil, ErrNotRegistered
+	}
+	return current.Campaign(ctx)
 }
 
 func (r *registry) GetService(_ context.Context, serviceName ...string) (service *Service, err error) {
-	name := currentInstance.ServiceName
+	var name string
 	if len(serviceName) > 0 {
 		name = serviceName[0]
+	} else if current := r.currentInstance(); current != nil {
+		name = current.ServiceName
 	}
 	value, ok := r.cache.Load(name)
 	if ok {
@@ -175,16 +299,69 @@ func (r *registry) GetServices(_ context.Context) (services map[string]*Service,
 	return
 }
 
-func (r *registry) RegisterEventHandler(handler EventHandler) {
-	if r.evs == nil {
-		r.evs = &eventWrapper{handler: handler}
-		return
+func (r *registry) Shutdown(ctx context.Context, drain time.Duration) (err error) {
+	r.mu.RLock()
+	rgs := make([]*registration, 0, len(r.registrations))
+	for _, rg := range r.registrations {
+		rgs = append(rgs, rg)
+	}
+	r.mu.RUnlock()
+
+	for _, rg := range rgs {
+		if e := rg.SetStatus(ctx, InstanceStatusDraining); e != nil && !errors.Is(e, ErrNotRegistered) {
+			g.Log().Warningf(ctx, "registry failed to drain %s: %v", rg.Instance().Identity(), e)
+		}
+	}
+	if len(rgs) > 0 && drain > 0 {
+		select {
+		case <-time.After(drain):
+		case <-ctx.Done():
+		}
+	}
+	for _, rg := range rgs {
+		if e := rg.Deregister(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	r.stop()
+	g.Log().Infof(ctx, "registry shutdown")
+	return
+}
+
+func (r *registry) Health() RegistrationState {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+	if current == nil {
+		return RegistrationStateDeregistered
 	}
-	p := r.evs
-	for p != nil && p.next != nil {
-		p = p.next
+	return current.Health()
+}
+
+func (r *registry) RegisterStateHandler(handler StateHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, handler)
+}
+
+func (r *registry) RegisterResyncHandler(handler ResyncHandler) (unsubscribe func()) {
+	h := &handler
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resyncs = append(r.resyncs, h)
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// copy on write, pushResync iterates without lock
+		resyncs := make([]*ResyncHandler, 0, len(r.resyncs))
+		for _, v := range r.resyncs {
+			if v != h {
+				resyncs = append(resyncs, v)
+			}
+		}
+		r.resyncs = resyncs
 	}
-	p.next = &eventWrapper{handler: handler}
 }
 
 func (r *registry) buildCache(ctx context.Context) {
@@ -200,7 +377,10 @@ func (r *registry) buildCache(ctx context.Context) {
 			return
 		}
 
+		// keep local health check result
 		serviceName := instance.ServiceName
+		r.healthMu.Lock()
+		instance = r.withHealth(instance)
 		v, ok := r.cache.Load(serviceName)
 		if !ok || v == nil {
 			service := new(Service)
@@ -209,6 +389,7 @@ func (r *registry) buildCache(ctx context.Context) {
 		} else {
 			v.(*Service).upsert(instance)
 		}
+		r.healthMu.Unlock()
 
 		size++
 	}
@@ -217,65 +398,173 @@ func (r *registry) buildCache(ctx context.Context) {
 }
 
 func (r *registry) watchAndUpdateCache(ctx context.Context) {
+	err := r.cli.Watch(ctx, r.cfg.getRegistryPrefix(), r.handleEvent)
+	if err != nil {
+		g.Log().Errorf(ctx, "registry failed to watchAndUpdateCache etcd: %v", err)
+	}
+}
+
+func (r *registry) handleEvent(ctx context.Context, e Event) {
 	pfx := r.cfg.getRegistryPrefix()
-	err := r.cli.Watch(ctx, pfx, func(ctx context.Context, e Event) {
+	switch e.Type {
+	case EventTypeResync:
+		g.Log().Warningf(ctx, "registry resync event: %v", e.Key)
+		r.resync(ctx)
+		r.pushResync()
+		return
+	case EventTypeDelete:
+		g.Log().Infof(ctx, "registry node delete event: %v", e.Key)
+		// check registered instance in case of lease lost
+		r.mu.RLock()
+		if rg, ok := r.registrations[strings.TrimPrefix(e.Key, pfx)]; ok {
+			rg.notifyLost()
+		}
+		r.mu.RUnlock()
+		identity := strings.TrimPrefix(e.Key, pfx)
+		r.unhealthy.Delete(identity)
+		// find and delete instance by e.key=instance.Identity()
 		var instance *Instance
-		switch e.Type {
-		case EventTypeDelete:
-			g.Log().Infof(ctx, "registry node delete event: %v", e.Key)
-			// find and delete instance by e.key=instance.Identity()
-			r.cache.Range(func(key, value interface{}) bool {
-				var (
-					deleted = false
-					service = value.(*Service)
-				)
-
-				instance = service.remove(strings.TrimPrefix(e.Key, pfx))
-				deleted = instance != nil
-
-				// remove empty service
-				if len(service.instances) == 0 {
-					r.cache.Delete(key)
-				}
-				return !deleted
-			})
-		case EventTypeCreate, EventTypeUpdate:
-			g.Log().Infof(ctx, "registry node register event: %v", e.Key)
-			instance = new(Instance)
-			if err := e.Value.Struct(&instance); err != nil {
-				g.Log().Errorf(ctx, "registry failed to upsert on watchAndUpdateCache: %v", err)
-				return
+		r.cache.Range(func(key, value interface{}) bool {
+			var (
+				deleted = false
+				service = value.(*Service)
+			)
+
+			instance = service.remove(identity)
+			deleted = instance != nil
+
+			// remove empty service
+			if service.Len() == 0 {
+				r.cache.Delete(key)
 			}
+			return !deleted
+		})
+		serviceName, _, _ := strings.Cut(identity, defaultIdentitySeparator)
+		r.notify(serviceName, e.Type, instance, nil, e.Revision)
+	case EventTypeCreate, EventTypeUpdate:
+		g.Log().Infof(ctx, "registry node register event: %v", e.Key)
+		instance := new(Instance)
+		if err := e.Value.Struct(&instance); err != nil {
+			g.Log().Errorf(ctx, "registry failed to upsert on watchAndUpdateCache: %v", err)
+			return
+		}
 
-			// get or create service
-			service, err := r.getOrCreateService(ctx, instance.ServiceName)
-			if err != nil {
-				g.Log().Errorf(ctx, "registry failed to upsert on watchAndUpdateCache: %v", err)
-				return
-			}
+		// get or create service
+		service, err := r.getOrCreateService(ctx, instance.ServiceName)
+		if err != nil {
+			g.Log().Errorf(ctx, "registry failed to upsert on watchAndUpdateCache: %v", err)
+			return
+		}
 
-			// upsert or insert instance to service
-			service.upsert(instance)
+		// upsert registered instance
+		r.mu.RLock()
+		if rg, ok := r.registrations[instance.Identity()]; ok {
+			rg.set(instance.clone())
+		}
+		r.mu.RUnlock()
 
-			// upsert currentInstance
-			if currentInstance != nil && instance.Id == currentInstance.Id {
-				currentInstance = instance.clone()
-			}
+		// upsert or insert instance to service, keep local health check result
+		r.healthMu.Lock()
+		var old *Instance
+		if e.Type == EventTypeUpdate {
+			old = service.get(instance.Identity())
+		}
+		service.upsert(r.withHealth(instance))
+		r.healthMu.Unlock()
+		r.notify(instance.ServiceName, e.Type, old, instance, e.Revision)
+	}
+}
+
+// resync local cache with database in case of watch events lost, differences are pushed as events
+func (r *registry) resync(ctx context.Context) {
+	// retry until succeed, cache is stale for good otherwise
+	response, err := r.cli.Get(ctx, r.cfg.getRegistryPrefix())
+	for err != nil {
+		g.Log().Errorf(ctx, "registry failed to resync cache, retry: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resyncRetryInterval):
+		}
+		response, err = r.cli.Get(ctx, r.cfg.getRegistryPrefix())
+	}
+	latest := make(map[string]*Instance)
+	for _, kv := range response {
+		instance := new(Instance)
+		if err = kv.Value.Struct(&instance); err != nil {
+			g.Log().Errorf(ctx, "registry failed to resync %s: %v", kv.Key, err)
+			continue
 		}
+		latest[instance.Identity()] = instance
+	}
 
-		r.pushEvent(instance, e.Type)
+	// remove instances not exist anymore
+	r.cache.Range(func(key, value interface{}) bool {
+		service := value.(*Service)
+		for _, instance := range service.Instances() {
+			if _, ok := latest[instance.Identity()]; ok {
+				continue
+			}
+			if removed := service.remove(instance.Identity()); removed != nil {
+				r.notify(removed.ServiceName, EventTypeDelete, removed, nil, 0)
+			}
+		}
+		if service.Len() == 0 {
+			r.cache.Delete(key)
+		}
+		return true
 	})
-	if err != nil {
-		g.Log().Errorf(ctx, "registry failed to watchAndUpdateCache etcd: %v", err)
+
+	// upsert changed instances
+	for identity, instance := range latest {
+		service, err := r.getOrCreateService(ctx, instance.ServiceName)
+		if err != nil {
+			continue
+		}
+		typ := EventTypeCreate
+		r.healthMu.Lock()
+		instance = r.withHealth(instance)
+		ori := service.get(identity)
+		if ori != nil {
+			if ori.String() == instance.String() {
+				r.healthMu.Unlock()
+				continue
+			}
+			typ = EventTypeUpdate
+		}
+		service.upsert(instance)
+		r.healthMu.Unlock()
+		r.notify(instance.ServiceName, typ, ori, instance, 0)
+	}
+	g.Log().Infof(ctx, "registry cache resynced, size=%v", len(latest))
+}
+
+// currentInstance registered by Init or New, nil if not registered
+func (r *registry) currentInstance() *Instance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Instance()
+}
+
+// pushState to handlers in order, handler should not block
+func (r *registry) pushState(instance *Instance, s RegistrationState) {
+	r.mu.RLock()
+	handlers := r.states
+	r.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(instance.clone(), s)
 	}
 }
 
-func (r *registry) pushEvent(instance *Instance, e EventType) {
-	ins := instance.clone()
-	p := r.evs
-	for p != nil {
-		go p.handler(ins, e)
-		p = p.next
+func (r *registry) pushResync() {
+	r.mu.RLock()
+	handlers := r.resyncs
+	r.mu.RUnlock()
+	for _, handler := range handlers {
+		(*handler)()
 	}
 }
 
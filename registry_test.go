@@ -2,16 +2,30 @@ package simple_registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
 )
 
+// resetGlobals allows Init again with a clean memory database
+func resetGlobals() {
+	Registry = nil
+	Storages = nil
+	global = nil
+	memoryStoresMu.Lock()
+	memoryStores = make(map[string]*memoryStore)
+	memoryStoresMu.Unlock()
+}
+
 func getConfig() Config {
 	return Config{
-		Type: TypeEtcd,
+		Type: TypeMemory,
 		Database: DatabaseConfig{
-			Endpoints: []string{"172.18.28.11:2379", "172.18.28.11:2380", "172.18.28.11:2381"},
+			Endpoints: []string{"memory"},
 			Username:  "",
 			Password:  "",
 			Tls:       nil,
@@ -21,6 +35,7 @@ func getConfig() Config {
 }
 
 func TestInitWithoutInstance(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig())
 	if err != nil {
 		t.Fatal(err)
@@ -29,6 +44,7 @@ func TestInitWithoutInstance(t *testing.T) {
 }
 
 func TestInit(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig(),
 		NewInstance("test-service").
 			WithAddress("127.0.0.1", 8080).
@@ -51,6 +67,7 @@ func TestInit(t *testing.T) {
 }
 
 func TestRegistry(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig(),
 		NewInstance("test-service").
 			WithAddress("127.0.0.1", 8080).
@@ -67,7 +84,7 @@ func TestRegistry(t *testing.T) {
 	}
 	fmt.Printf("service: %+v\n", service.Instances())
 	instance := service.Instances()[0]
-	if instance.Id != currentInstance.Id {
+	if instance.Id != Registry.(*registry).currentInstance().Id {
 		t.Fatal("instance id not equal")
 	}
 
@@ -90,3 +107,299 @@ func TestRegistry(t *testing.T) {
 		return
 	}
 }
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("http").WithAddress("127.0.0.1", 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpc, err := cli.Registry.Register(ctx, NewInstance("grpc").WithAddress("127.0.0.1", 9090))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := cli.Registry.Register(ctx, NewInstance("admin").WithAddress("127.0.0.1", 9091))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.Registry.Register(ctx, grpc.Instance()); err != ErrAlreadyRegistered {
+		t.Fatalf("expect already registered, got %v", err)
+	}
+	for _, name := range []string{"http", "grpc", "admin"} {
+		if service, err := cli.Registry.GetService(ctx, name); err != nil || service.Len() != 1 {
+			t.Fatalf("service %s not registered: %v", name, err)
+		}
+	}
+
+	// update
+	updated := grpc.Instance().WithMetaData(map[string]interface{}{"version": "v2"})
+	if err = grpc.Update(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	changed := grpc.Instance()
+	changed.ServiceName = "other"
+	if err = grpc.Update(ctx, changed); err != ErrIdentityChanged {
+		t.Fatalf("expect identity changed, got %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	service, _ := cli.Registry.GetService(ctx, "grpc")
+	if v := service.Instances()[0].Meta["version"]; v != "v2" {
+		t.Fatalf("meta not updated: %v", v)
+	}
+
+	// deregister one instance, others still alive
+	if err = admin.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = admin.Update(ctx, admin.Instance()); err != ErrNotRegistered {
+		t.Fatalf("expect not registered, got %v", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err = cli.Registry.GetService(ctx, "admin"); err != ErrServiceNotFound {
+		t.Fatalf("expect admin deregistered, got %v", err)
+	}
+
+	// default deregister current instance only
+	if err = cli.Registry.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err = cli.Registry.GetService(ctx, "http"); err != ErrServiceNotFound {
+		t.Fatalf("expect http deregistered, got %v", err)
+	}
+	if _, err = cli.Registry.GetService(ctx, "grpc"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateMeta(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("svc").WithMetaData(map[string]interface{}{"weight": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cli.Registry.(*registry)
+	key := r.currentInstance().registryIdentity(r.cfg.getRegistryPrefix())
+	store := r.cli.(*memory).store
+	store.mu.Lock()
+	lease := store.kvs[key].lease
+	store.mu.Unlock()
+
+	// wait for register event delivered
+	time.Sleep(time.Millisecond * 100)
+	events := make(chan EventType, 10)
+	cli.Registry.RegisterEventHandler(func(_ *Instance, e EventType) {
+		events <- e
+	})
+	if err = cli.Registry.UpdateMeta(ctx, map[string]interface{}{"weight": 2}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e != EventTypeUpdate {
+			t.Fatalf("unexpected event: %s", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait update event timeout")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected extra event: %s", e)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	if v := r.currentInstance().Meta["weight"]; gconv.Int(v) != 2 {
+		t.Fatalf("current instance not updated: %v", v)
+	}
+	store.mu.Lock()
+	if store.kvs[key].lease != lease {
+		t.Fatal("lease changed")
+	}
+	store.mu.Unlock()
+}
+
+func TestSetStatus(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("svc").WithAddress("127.0.0.1", 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	starting, err := cli.Registry.Register(ctx, NewInstance("svc").
+		WithAddress("127.0.0.2", 8080).
+		WithStatus(InstanceStatusStarting))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	service, _ := cli.Registry.GetService(ctx, "svc")
+	if service.Len() != 2 || len(service.ServingInstances()) != 1 {
+		t.Fatalf("serving instances not match: %+v", service.ServingInstances())
+	}
+
+	if err = starting.SetStatus(ctx, InstanceStatusServing); err != nil {
+		t.Fatal(err)
+	}
+	if err = cli.Registry.SetStatus(ctx, InstanceStatusDraining); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	serving := service.ServingInstances()
+	if len(serving) != 1 || serving[0].Host != "127.0.0.2" {
+		t.Fatalf("serving instances not match: %+v", serving)
+	}
+	// draining instance still registered
+	if service.Len() != 2 {
+		t.Fatalf("draining instance removed")
+	}
+
+	// status kept if not provided on update
+	if err = starting.Update(ctx, starting.Instance().WithStatus("")); err != nil {
+		t.Fatal(err)
+	}
+	if s := starting.Instance().Status; s != InstanceStatusServing {
+		t.Fatalf("status changed on update: %s", s)
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}, HeartBeatInterval: 1},
+		NewInstance("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(chan RegistrationState, 10)
+	cli.Registry.RegisterStateHandler(func(_ *Instance, s RegistrationState) {
+		states <- s
+	})
+	if s := cli.Registry.Health(); s != RegistrationStateRegistered {
+		t.Fatalf("unexpected state: %s", s)
+	}
+
+	r := cli.Registry.(*registry)
+	key := r.currentInstance().registryIdentity(r.cfg.getRegistryPrefix())
+	var expect = func(s RegistrationState) {
+		select {
+		case state := <-states:
+			if state != s {
+				t.Fatalf("expect %s, got %s", s, state)
+			}
+		case <-time.After(time.Second * 3):
+			t.Fatalf("wait state %s timeout", s)
+		}
+	}
+	var exists = func() bool {
+		kvs, _ := r.cli.Get(ctx, key)
+		return len(kvs) > 0
+	}
+
+	// detected by delete event
+	if err = r.cli.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	expect(RegistrationStateLost)
+	expect(RegistrationStateRegistered)
+	if !exists() {
+		t.Fatal("not registered again")
+	}
+
+	// detected by periodic check, lease removed silently
+	store := r.cli.(*memory).store
+	store.mu.Lock()
+	delete(store.leases, store.kvs[key].lease)
+	delete(store.kvs, key)
+	store.mu.Unlock()
+	expect(RegistrationStateLost)
+	expect(RegistrationStateRegistered)
+	if !exists() {
+		t.Fatal("not registered again")
+	}
+
+	// deregister stops monitor
+	if err = cli.Registry.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(RegistrationStateDeregistered)
+	time.Sleep(time.Millisecond * 1500)
+	if exists() {
+		t.Fatal("registered again after deregister")
+	}
+}
+
+func TestResync(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}},
+		NewInstance("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cli.Registry.(*registry)
+	registered := r.currentInstance()
+
+	// change database silently as if watch events lost
+	other := NewInstance("other").fillInfo()
+	store := r.cli.(*memory).store
+	store.mu.Lock()
+	delete(store.kvs, registered.registryIdentity(r.cfg.getRegistryPrefix()))
+	store.kvs[other.registryIdentity(r.cfg.getRegistryPrefix())] = &memoryValue{value: other.String()}
+	store.mu.Unlock()
+
+	resynced := make(chan struct{}, 1)
+	unsubscribe := cli.Registry.RegisterResyncHandler(func() {
+		resynced <- struct{}{}
+	})
+	r.handleEvent(ctx, Event{KV: KV{Key: r.cfg.getRegistryPrefix()}, Type: EventTypeResync})
+	select {
+	case <-resynced:
+	default:
+		t.Fatal("resync handler not called")
+	}
+
+	if _, err = cli.Registry.GetService(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if service, err := cli.Registry.GetService(ctx, "svc"); err == nil {
+		t.Fatalf("deleted instance not removed: %v", service.Instances())
+	}
+
+	unsubscribe()
+	r.handleEvent(ctx, Event{KV: KV{Key: r.cfg.getRegistryPrefix()}, Type: EventTypeResync})
+	select {
+	case <-resynced:
+		t.Fatal("resync handler called after unsubscribed")
+	default:
+	}
+}
+
+// flakyGetDB fails Get for the first times
+type flakyGetDB struct {
+	Database
+	fails atomic.Int32
+}
+
+func (f *flakyGetDB) Get(ctx context.Context, key string) ([]*KV, error) {
+	if f.fails.Add(-1) >= 0 {
+		return nil, errors.New("unavailable")
+	}
+	return f.Database.Get(ctx, key)
+}
+
+func TestResync_Retry(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}}, NewInstance("svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := cli.Registry.(*registry)
+	db := &flakyGetDB{Database: src.cli}
+	db.fails.Store(1)
+	r := &registry{cfg: src.cfg, cli: db, registrations: make(map[string]*registration), subs: make(map[*subscriber]struct{})}
+	r.resync(ctx)
+	if _, err = r.GetService(ctx, "svc"); err != nil {
+		t.Fatalf("cache not resynced after retry: %v", err)
+	}
+}
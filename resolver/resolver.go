@@ -0,0 +1,123 @@
+// Package resolver implements grpc resolver backed by registry,
+// dial target like "registry:///service-name".
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+
+	registry "github.com/junqirao/simple-registry"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme default scheme of builder
+const Scheme = "registry"
+
+type (
+	// Meta of instance stored in resolver.Address.BalancerAttributes
+	Meta map[string]interface{}
+	// metaKey of Meta in attributes
+	metaKey struct{}
+
+	builder struct {
+		reg    registry.Interface
+		scheme string
+	}
+	registryResolver struct {
+		reg         registry.Interface
+		name        string
+		cc          resolver.ClientConn
+		mu          sync.Mutex // serialize updates and protect closed
+		closed      bool
+		unsubscribe []func()
+	}
+)
+
+// NewBuilder of resolver, scheme default "registry"
+func NewBuilder(reg registry.Interface, scheme ...string) resolver.Builder {
+	s := Scheme
+	if len(scheme) > 0 && scheme[0] != "" {
+		s = scheme[0]
+	}
+	return &builder{reg: reg, scheme: s}
+}
+
+// Register builder to grpc globally, must be called at initialization time
+func Register(reg registry.Interface, scheme ...string) {
+	resolver.Register(NewBuilder(reg, scheme...))
+}
+
+// MetaFromAddress returns instance meta of address, nil if not exist
+func MetaFromAddress(addr resolver.Address) Meta {
+	meta, _ := addr.BalancerAttributes.Value(metaKey{}).(Meta)
+	return meta
+}
+
+// Equal implements attributes comparison
+func (m Meta) Equal(o interface{}) bool {
+	om, ok := o.(Meta)
+	return ok && reflect.DeepEqual(m, om)
+}
+
+func (b *builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &registryResolver{reg: b.reg, name: target.Endpoint(), cc: cc}
+	r.unsubscribe = append(r.unsubscribe,
+		b.reg.RegisterServiceEventHandler(r.name, func(_ *registry.Instance, _ registry.EventType) {
+			r.update()
+		}),
+		b.reg.RegisterResyncHandler(r.update),
+	)
+	r.update()
+	return r, nil
+}
+
+func (b *builder) Scheme() string {
+	return b.scheme
+}
+
+func (r *registryResolver) ResolveNow(_ resolver.ResolveNowOptions) {
+	r.update()
+}
+
+func (r *registryResolver) Close() {
+	for _, unsubscribe := range r.unsubscribe {
+		unsubscribe()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+// update addresses from local cache of registry
+func (r *registryResolver) update() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+
+	var instances []*registry.Instance
+	service, err := r.reg.GetService(context.Background(), r.name)
+	switch {
+	case err == nil:
+		instances = service.ServingInstances()
+	case errors.Is(err, registry.ErrServiceNotFound):
+		// service removed with its last instance, stop dialing stale addresses
+	default:
+		r.cc.ReportError(err)
+		return
+	}
+	addrs := make([]resolver.Address, 0, len(instances))
+	for _, instance := range instances {
+		addrs = append(addrs, resolver.Address{
+			Addr:               net.JoinHostPort(instance.Host, strconv.Itoa(instance.Port)),
+			BalancerAttributes: attributes.New(metaKey{}, Meta(instance.Meta)),
+		})
+	}
+	_ = r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
@@ -0,0 +1,171 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	registry "github.com/junqirao/simple-registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+type fakeClientConn struct {
+	mu    sync.Mutex
+	state resolver.State
+	err   error
+}
+
+func (f *fakeClientConn) UpdateState(state resolver.State) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state, f.err = state, nil
+	return nil
+}
+
+func (f *fakeClientConn) ReportError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeClientConn) NewAddress(_ []resolver.Address) {}
+
+func (f *fakeClientConn) NewServiceConfig(_ string) {}
+
+func (f *fakeClientConn) ParseServiceConfig(_ string) *serviceconfig.ParseResult { return nil }
+
+func (f *fakeClientConn) get() (resolver.State, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state, f.err
+}
+
+func mustParse(t *testing.T, target string) *url.URL {
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func newClient(t *testing.T) *registry.Client {
+	cli, err := registry.New(context.Background(), registry.Config{
+		Type:     registry.TypeMemory,
+		Database: registry.DatabaseConfig{Endpoints: []string{t.Name()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// memory database is shared by test name, deregister for next run
+	t.Cleanup(func() { _ = cli.Shutdown(context.Background(), 0) })
+	return cli
+}
+
+func TestResolver_Update(t *testing.T) {
+	ctx := context.Background()
+	cli := newClient(t)
+	rg, err := cli.Registry.Register(ctx, registry.NewInstance("grpc").
+		WithAddress("127.0.0.1", 9090).
+		WithMetaData(map[string]interface{}{"weight": "10"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := &fakeClientConn{}
+	r, err := NewBuilder(cli.Registry).Build(resolver.Target{URL: *mustParse(t, "registry:///grpc")}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	state, _ := cc.get()
+	if len(state.Addresses) != 1 || state.Addresses[0].Addr != "127.0.0.1:9090" {
+		t.Fatalf("addresses not match: %+v", state.Addresses)
+	}
+	if v := MetaFromAddress(state.Addresses[0])["weight"]; v != "10" {
+		t.Fatalf("meta not match: %v", v)
+	}
+
+	// new instance
+	last, err := cli.Registry.Register(ctx, registry.NewInstance("grpc").WithAddress("127.0.0.2", 9090))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if state, _ = cc.get(); len(state.Addresses) != 2 {
+		t.Fatalf("new instance not resolved: %+v", state.Addresses)
+	}
+
+	// meta changed
+	if err = rg.UpdateMeta(ctx, map[string]interface{}{"weight": "20"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	state, _ = cc.get()
+	for _, addr := range state.Addresses {
+		if addr.Addr == "127.0.0.1:9090" && MetaFromAddress(addr)["weight"] != "20" {
+			t.Fatalf("meta not updated: %v", MetaFromAddress(addr))
+		}
+	}
+
+	// instance removed
+	if err = rg.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if state, _ = cc.get(); len(state.Addresses) != 1 || state.Addresses[0].Addr != "127.0.0.2:9090" {
+		t.Fatalf("removed instance still resolved: %+v", state.Addresses)
+	}
+
+	// last instance removed
+	if err = last.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if state, err = cc.get(); err != nil || len(state.Addresses) != 0 {
+		t.Fatalf("last removed instance still resolved: %v %+v", err, state.Addresses)
+	}
+}
+
+func TestResolver_Dial(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	ctx := context.Background()
+	cli := newClient(t)
+	if _, err = cli.Registry.Register(ctx, registry.NewInstance("health").
+		WithAddress("127.0.0.1", lis.Addr().(*net.TCPAddr).Port)); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.Dial("registry:///health",
+		grpc.WithResolvers(NewBuilder(cli.Registry)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	res, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status: %s", res.Status)
+	}
+}
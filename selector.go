@@ -0,0 +1,206 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+// selector operator define
+const (
+	selectorEquals       selectorOperator = "="
+	selectorNotEquals    selectorOperator = "!="
+	selectorIn           selectorOperator = "in"
+	selectorNotIn        selectorOperator = "notin"
+	selectorExists       selectorOperator = "exists"
+	selectorDoesNotExist selectorOperator = "!"
+)
+
+var (
+	ErrInvalidSelector = errors.New("invalid selector")
+
+	selectorSetPattern = regexp.MustCompile(`^([^\s=!(),]+)\s+(in|notin)\s*\(([^()]*)\)$`)
+	selectorKeyPattern = regexp.MustCompile(`^[^\s=!(),]+$`)
+)
+
+type (
+	// Selector of instances by meta, kubernetes label selector style, e.g.
+	// "env=prod,version!=v1,zone in (a,b),tier notin (cache),canary,!legacy".
+	// requirements are combined with AND, empty selector matches all
+	Selector            []selectorRequirement
+	selectorRequirement struct {
+		key      string
+		operator selectorOperator
+		values   []string
+	}
+	selectorOperator string
+)
+
+// ParseSelector parse kubernetes style label selector
+func ParseSelector(selector string) (s Selector, err error) {
+	for _, part := range splitSelector(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req selectorRequirement
+		if req, err = parseRequirement(part); err != nil {
+			return nil, err
+		}
+		s = append(s, req)
+	}
+	return
+}
+
+// MustParseSelector parse selector and panic if invalid
+func MustParseSelector(selector string) Selector {
+	s, err := ParseSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// splitSelector by comma outside parentheses
+func splitSelector(selector string) (parts []string) {
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+func parseRequirement(part string) (req selectorRequirement, err error) {
+	invalid := fmt.Errorf("%w: %q", ErrInvalidSelector, part)
+	if m := selectorSetPattern.FindStringSubmatch(part); m != nil {
+		req = selectorRequirement{key: m[1], operator: selectorOperator(m[2])}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				req.values = append(req.values, v)
+			}
+		}
+		if len(req.values) == 0 {
+			return req, invalid
+		}
+		return
+	}
+
+	var key, value string
+	switch {
+	case strings.HasPrefix(part, "!"):
+		key, req.operator = strings.TrimSpace(part[1:]), selectorDoesNotExist
+	case strings.Contains(part, "!="):
+		key, value, _ = strings.Cut(part, "!=")
+		req.operator = selectorNotEquals
+	case strings.Contains(part, "=="):
+		key, value, _ = strings.Cut(part, "==")
+		req.operator = selectorEquals
+	case strings.Contains(part, "="):
+		key, value, _ = strings.Cut(part, "=")
+		req.operator = selectorEquals
+	default:
+		key, req.operator = part, selectorExists
+	}
+	req.key = strings.TrimSpace(key)
+	if !selectorKeyPattern.MatchString(req.key) {
+		return req, invalid
+	}
+	if req.operator == selectorEquals || req.operator == selectorNotEquals {
+		value = strings.TrimSpace(value)
+		if strings.ContainsAny(value, "=!(), ") {
+			return req, invalid
+		}
+		req.values = []string{value}
+	}
+	return
+}
+
+// Matches meta of instance, not equals and not in match if key not exist
+func (s Selector) Matches(ins *Instance) bool {
+	for _, req := range s {
+		if !req.matches(ins.Meta) {
+			return false
+		}
+	}
+	return true
+}
+
+// String of selector in canonical form
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, req := range s {
+		switch req.operator {
+		case selectorExists:
+			parts = append(parts, req.key)
+		case selectorDoesNotExist:
+			parts = append(parts, "!"+req.key)
+		case selectorIn, selectorNotIn:
+			parts = append(parts, fmt.Sprintf("%s %s (%s)", req.key, req.operator, strings.Join(req.values, ",")))
+		default:
+			parts = append(parts, req.key+string(req.operator)+req.values[0])
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r selectorRequirement) matches(meta map[string]interface{}) bool {
+	v, ok := meta[r.key]
+	switch r.operator {
+	case selectorExists:
+		return ok
+	case selectorDoesNotExist:
+		return !ok
+	case selectorEquals, selectorIn:
+		return ok && slices.Contains(r.values, gconv.String(v))
+	case selectorNotEquals, selectorNotIn:
+		return !ok || !slices.Contains(r.values, gconv.String(v))
+	}
+	return false
+}
+
+// Select copy of instances matched selector
+func (s *Service) Select(selector Selector) []*Instance {
+	var ins []*Instance
+	s.Range(func(instance *Instance) bool {
+		if selector.Matches(instance) {
+			ins = append(ins, instance.clone())
+		}
+		return true
+	})
+	return ins
+}
+
+func (r *registry) Select(_ context.Context, selector Selector, serviceName ...string) (instances []*Instance, err error) {
+	if len(serviceName) == 0 {
+		r.cache.Range(func(_, value interface{}) bool {
+			instances = append(instances, value.(*Service).Select(selector)...)
+			return true
+		})
+		return
+	}
+	names := slices.Clone(serviceName)
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		v, ok := r.cache.Load(name)
+		if !ok {
+			return nil, ErrServiceNotFound
+		}
+		instances = append(instances, v.(*Service).Select(selector)...)
+	}
+	return
+}
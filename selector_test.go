@@ -0,0 +1,88 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSelector(t *testing.T) {
+	ins := NewInstance("svc").WithMetaData(map[string]interface{}{
+		"env":     "prod",
+		"version": "v2",
+		"zone":    "a",
+		"weight":  10,
+		"canary":  true,
+	})
+	cases := map[string]bool{
+		"":                                 true,
+		"env=prod":                         true,
+		"env==prod":                        true,
+		"env=test":                         false,
+		"env!=test":                        true,
+		"missing!=x":                       true,
+		"weight=10":                        true,
+		"zone in (a, b)":                   true,
+		"zone in (b,c)":                    false,
+		"zone notin (b,c)":                 true,
+		"missing notin (b)":                true,
+		"missing in (b)":                   false,
+		"canary":                           true,
+		"!canary":                          false,
+		"!legacy":                          true,
+		"env=prod,zone in (a,b),!legacy":   true,
+		"env=prod, version notin (v1, v2)": false,
+	}
+	for selector, expected := range cases {
+		s, err := ParseSelector(selector)
+		if err != nil {
+			t.Fatalf("parse %q: %v", selector, err)
+		}
+		if s.Matches(ins) != expected {
+			t.Fatalf("selector %q (%s) expect %v", selector, s, expected)
+		}
+	}
+
+	for _, selector := range []string{"env=a=b", "zone in ()", "zone in (a", "=prod", "a b", "!"} {
+		if _, err := ParseSelector(selector); !errors.Is(err, ErrInvalidSelector) {
+			t.Fatalf("expect invalid selector %q, got %v", selector, err)
+		}
+	}
+}
+
+func TestRegistrySelect(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range []map[string]interface{}{
+		{"zone": "a", "version": "v1"},
+		{"zone": "b", "version": "v2"},
+		{"zone": "c", "version": "v2"},
+	} {
+		if _, err = cli.Registry.Register(ctx, NewInstance("svc").WithMetaData(meta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = cli.Registry.Register(ctx, NewInstance("other").WithMetaData(map[string]interface{}{"version": "v2"})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	selector := MustParseSelector("version=v2,zone notin (c)")
+	service, _ := cli.Registry.GetService(ctx, "svc")
+	if ins := service.Select(selector); len(ins) != 1 || ins[0].Meta["zone"] != "b" {
+		t.Fatalf("service select not match: %+v", ins)
+	}
+	if ins, _ := cli.Registry.Select(ctx, selector); len(ins) != 2 {
+		t.Fatalf("registry select not match: %+v", ins)
+	}
+	if ins, _ := cli.Registry.Select(ctx, MustParseSelector("version"), "other"); len(ins) != 1 || ins[0].ServiceName != "other" {
+		t.Fatalf("registry select of service not match: %+v", ins)
+	}
+	if _, err = cli.Registry.Select(ctx, selector, "svc", "unknown"); err != ErrServiceNotFound {
+		t.Fatalf("expect service not found, got %v", err)
+	}
+}
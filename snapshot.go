@@ -0,0 +1,67 @@
+package simple_registry
+
+var emptyServiceSnapshot = newServiceSnapshot(0, nil)
+
+// ServiceSnapshot immutable view of instances of service at version,
+// instances shared by snapshots must not be modified
+type ServiceSnapshot struct {
+	version   uint64
+	instances []*Instance
+	serving   []*Instance
+}
+
+func newServiceSnapshot(version uint64, instances []*Instance) *ServiceSnapshot {
+	snap := &ServiceSnapshot{version: version, instances: instances}
+	for _, instance := range instances {
+		if instance.Serving() {
+			snap.serving = append(snap.serving, instance)
+		}
+	}
+	return snap
+}
+
+// Version of snapshot
+func (s *ServiceSnapshot) Version() uint64 {
+	return s.version
+}
+
+// Len of instances
+func (s *ServiceSnapshot) Len() int {
+	return len(s.instances)
+}
+
+// Range instances, instance must not be modified
+func (s *ServiceSnapshot) Range(h func(instance *Instance) bool) {
+	for _, instance := range s.instances {
+		if !h(instance) {
+			break
+		}
+	}
+}
+
+// RangeServing serving instances, instance must not be modified
+func (s *ServiceSnapshot) RangeServing(h func(instance *Instance) bool) {
+	for _, instance := range s.serving {
+		if !h(instance) {
+			break
+		}
+	}
+}
+
+// Instances slice copy of snapshot
+func (s *ServiceSnapshot) Instances() []*Instance {
+	return cloneInstances(s.instances)
+}
+
+// ServingInstances slice copy of serving instances of snapshot
+func (s *ServiceSnapshot) ServingInstances() []*Instance {
+	return cloneInstances(s.serving)
+}
+
+func cloneInstances(instances []*Instance) []*Instance {
+	var ins []*Instance
+	for _, instance := range instances {
+		ins = append(ins, instance.clone())
+	}
+	return ins
+}
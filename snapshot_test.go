@@ -0,0 +1,54 @@
+package simple_registry
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestServiceSnapshot(t *testing.T) {
+	service := new(Service)
+	empty := service.Snapshot()
+	if empty.Version() != 0 || empty.Len() != 0 || service.ChangedSince(0) {
+		t.Fatalf("unexpected empty snapshot: %d %d", empty.Version(), empty.Len())
+	}
+
+	a := NewInstance("svc").WithAddress("127.0.0.1", 8080).fillInfo()
+	b := NewInstance("svc").WithAddress("127.0.0.1", 8081).WithStatus(InstanceStatusDraining).fillInfo()
+	service.append(a, b)
+	snap := service.Snapshot()
+	if !service.ChangedSince(empty.Version()) || snap.Len() != 2 || len(snap.ServingInstances()) != 1 {
+		t.Fatalf("unexpected snapshot: %d %d", snap.Version(), snap.Len())
+	}
+
+	// snapshot not affected by later changes
+	service.upsert(a.clone().WithStatus(InstanceStatusDraining))
+	service.remove(b.Identity())
+	if snap.Len() != 2 || len(snap.ServingInstances()) != 1 || snap.Version() == service.Version() {
+		t.Fatalf("snapshot modified: %d %d", snap.Version(), snap.Len())
+	}
+	if service.Len() != 1 || len(service.ServingInstances()) != 0 || service.Version() != snap.Version()+2 {
+		t.Fatalf("unexpected current snapshot: %d %d", service.Version(), service.Len())
+	}
+
+	// concurrent readers see consistent view
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s := service.Snapshot()
+				n := 0
+				s.Range(func(*Instance) bool { n++; return true })
+				if n != s.Len() {
+					t.Errorf("inconsistent snapshot: %d != %d", n, s.Len())
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 1000; j++ {
+		service.upsert(NewInstance("svc").WithAddress("127.0.0.1", 9000+j%10).fillInfo())
+	}
+	wg.Wait()
+}
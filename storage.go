@@ -19,20 +19,41 @@ type (
 		SetTTL(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error)
 		// Delete value
 		Delete(ctx context.Context, key string) (err error)
+		// CompareAndSet value if compare matched, *ConflictError if not matched
+		CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error)
+		// Create value if key not exist, *ConflictError if exist
+		Create(ctx context.Context, key string, value interface{}) (err error)
+		// CreateTTL create value with ttl in second if key not exist
+		CreateTTL(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error)
+		// Txn sets and deletes values atomically if all guards matched, *ConflictError if not matched,
+		// ErrNotSupported in nacos
+		Txn(ctx context.Context, guards []Guard, ops ...Op) (err error)
 	}
 	// StorageEventHandler process storage event
 	StorageEventHandler func(t EventType, key string, value interface{})
 	storages            struct {
-		ctx context.Context
-		cfg Config
-		db  Database
-		m   sync.Map // key: (name)string, value: Storage
-		evs sync.Map // key: (name)string, value: StorageEventHandler
+		ctx    context.Context
+		cfg    Config
+		db     Database
+		m      sync.Map // key: (name)string, value: Storage
+		evs    sync.Map // key: (name)string, value: StorageEventHandler
+		resync sync.Map // key: (name)string, value: ResyncHandler
+		stop   context.CancelFunc
+
+		pendingMu sync.Mutex
+		pending   []Event // events of the revision not completed yet
+	}
+	// storageEvent with key relative to storage
+	storageEvent struct {
+		Event
+		key string
 	}
 )
 
 func newStorages(ctx context.Context, cfg Config, db Database) *storages {
-	sto := &storages{ctx: ctx, cfg: cfg, db: db}
+	sto := &storages{cfg: cfg, db: db}
+	sto.ctx, sto.stop = context.WithCancel(ctx)
+	ctx = sto.ctx
 	// watch and update caches event bus
 	sto.watchAndUpdateCaches(ctx)
 	return sto
@@ -58,30 +79,72 @@ func (s *storages) GetStorage(name string, uncached ...bool) Storage {
 }
 
 func (s *storages) watchAndUpdateCaches(ctx context.Context) {
+	err := s.db.Watch(ctx, s.cfg.getStoragePrefix(), s.handleEvent)
+	if err != nil {
+		g.Log().Errorf(ctx, "failed to watch and update caches at storage: %s", err.Error())
+	}
+}
+
+func (s *storages) handleEvent(ctx context.Context, e Event) {
+	if e.Type == EventTypeResync {
+		s.pendingMu.Lock()
+		s.pending = nil
+		s.pendingMu.Unlock()
+		g.Log().Warningf(ctx, "storage resync event: %v", e.Key)
+		s.m.Range(func(name, sto any) bool {
+			sto.(*cachedStorage).buildCache(ctx)
+			if h, ok := s.resync.Load(name); ok {
+				h.(ResyncHandler)()
+			}
+			return true
+		})
+		return
+	}
+
+	// events of a transaction are applied together
+	s.pendingMu.Lock()
+	s.pending = append(s.pending, e)
+	if e.More {
+		s.pendingMu.Unlock()
+		return
+	}
+	es := s.pending
+	s.pending = nil
+	s.pendingMu.Unlock()
+
+	var (
+		names  []string
+		groups = make(map[string][]storageEvent)
+	)
 	pfx := s.cfg.getStoragePrefix()
-	err := s.db.Watch(ctx, pfx, func(ctx context.Context, e Event) {
+	for _, e := range es {
 		pos := strings.Split(strings.TrimPrefix(e.Key, pfx), s.cfg.Storage.Separator)
-		if len(pos) == 0 {
-			return
-		}
 		name := pos[0]
-		key := strings.Join(pos[1:], s.cfg.Storage.Separator)
-
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], storageEvent{Event: e, key: strings.Join(pos[1:], s.cfg.Storage.Separator)})
+	}
+	for _, name := range names {
 		// internal event
 		if sto, ok := s.m.Load(name); ok {
-			sto.(*cachedStorage).handleEvent(e.Type, key, e.Value)
+			sto.(*cachedStorage).handleEvents(groups[name]...)
 		}
 
 		// push to event handler
 		if ev, ok := s.evs.Load(name); ok {
-			ev.(StorageEventHandler)(e.Type, key, e.Value)
+			for _, e := range groups[name] {
+				ev.(StorageEventHandler)(e.Type, e.key, e.Value)
+			}
 		}
-	})
-	if err != nil {
-		g.Log().Errorf(ctx, "failed to watch and update caches at storage: %s", err.Error())
 	}
 }
 
 func (s *storages) SetEventHandler(name string, handler StorageEventHandler) {
 	s.evs.Store(name, handler)
 }
+
+// SetResyncHandler called after cache of storage rebuilt in case of watch events lost
+func (s *storages) SetResyncHandler(name string, handler ResyncHandler) {
+	s.resync.Store(name, handler)
+}
@@ -16,6 +16,7 @@ var (
 type (
 	cachedStorage struct {
 		db   *storage
+		mu   sync.RWMutex // protect tree, so that ops of txn are applied in one step
 		root *storageNode
 	}
 
@@ -41,6 +42,8 @@ func (c *cachedStorage) Get(_ context.Context, key ...string) (vs []*KV, err err
 		k = key[0]
 	}
 
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	node := c.root
 	var dfs func(node *storageNode)
 	dfs = func(node *storageNode) {
@@ -78,13 +81,15 @@ func (c *cachedStorage) Get(_ context.Context, key ...string) (vs []*KV, err err
 	return
 }
 
+// Set by txn without guard, so that revision of cache is known
+// and stale events arrive later are ignored
 func (c *cachedStorage) Set(ctx context.Context, key string, value interface{}) (err error) {
-	err = c.db.Set(ctx, key, value)
+	revision, err := c.db.txn(ctx, nil, OpSet(key, value))
 	if err != nil {
 		return
 	}
 
-	c.setCache(c.db.buildStorageKey(key), value)
+	c.setCache(&KV{Key: c.db.buildStorageKey(key), Value: g.NewVar(value), ModRevision: revision})
 	return
 }
 
@@ -94,12 +99,69 @@ func (c *cachedStorage) SetTTL(ctx context.Context, key string, value interface{
 		return
 	}
 
-	c.setCache(c.db.buildStorageKey(key), value)
+	c.refresh(ctx, key)
 	return
 }
 
-func (c *cachedStorage) setCache(key string, value interface{}) {
-	pos := strings.Split(strings.TrimPrefix(key, c.db.buildStorageKey()), c.db.cfg.Separator)
+func (c *cachedStorage) CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error) {
+	if err = c.db.CompareAndSet(ctx, key, cmp, value); err != nil {
+		return
+	}
+	c.refresh(ctx, key)
+	return
+}
+
+func (c *cachedStorage) Create(ctx context.Context, key string, value interface{}) (err error) {
+	return c.CreateTTL(ctx, key, value, 0)
+}
+
+func (c *cachedStorage) CreateTTL(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	if err = c.db.CreateTTL(ctx, key, value, ttl, keepalive...); err != nil {
+		return
+	}
+	c.refresh(ctx, key)
+	return
+}
+
+// refresh cache of key from database, so that revision is up to date
+func (c *cachedStorage) refresh(ctx context.Context, key string) {
+	kvs, err := c.db.Database.Get(ctx, c.db.fullKey(key))
+	if err != nil {
+		g.Log().Warningf(ctx, "failed to refresh cache of %s: %s", key, err.Error())
+		return
+	}
+	for _, kv := range kvs {
+		c.setCache(kv)
+	}
+}
+
+func (c *cachedStorage) Txn(ctx context.Context, guards []Guard, ops ...Op) (err error) {
+	revision, err := c.db.txn(ctx, guards, ops...)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, op := range ops {
+		if op.typ == opSet {
+			c.set(&KV{Key: c.db.buildStorageKey(op.key), Value: g.NewVar(op.value), ModRevision: revision})
+		} else {
+			c.delete(op.key, revision)
+		}
+	}
+	return
+}
+
+func (c *cachedStorage) setCache(kv *KV) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(kv)
+}
+
+// set kv in tree, caller must hold lock
+func (c *cachedStorage) set(kv *KV) {
+	pos := strings.Split(strings.TrimPrefix(kv.Key, c.db.buildStorageKey()), c.db.cfg.Separator)
 	node := c.root
 	for _, po := range pos {
 		if po == "" {
@@ -117,7 +179,7 @@ func (c *cachedStorage) setCache(key string, value interface{}) {
 		node = n
 	}
 
-	node.updateOrInsertValue(key, value)
+	node.updateOrInsertValue(kv)
 }
 
 func (c *cachedStorage) Delete(ctx context.Context, key string) (err error) {
@@ -126,12 +188,20 @@ func (c *cachedStorage) Delete(ctx context.Context, key string) (err error) {
 		return
 	}
 
-	c.remove(key)
+	c.remove(key, 0)
 	return
 }
 
-func (c *cachedStorage) remove(key string) {
-	pos := strings.Split(strings.TrimPrefix(key, c.db.buildStorageKey()), c.db.cfg.Separator)
+func (c *cachedStorage) remove(key string, revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delete(key, revision)
+}
+
+// delete key relative to storage from tree at revision, caller must hold lock
+func (c *cachedStorage) delete(key string, revision int64) {
+	// prefixed key deletes node of last layer
+	pos := strings.Split(strings.TrimSuffix(strings.TrimPrefix(key, c.db.buildStorageKey()), c.db.cfg.Separator), c.db.cfg.Separator)
 	node := c.root
 
 	for i := 0; i < len(pos)-1; i++ {
@@ -151,11 +221,11 @@ func (c *cachedStorage) remove(key string) {
 		if strings.HasSuffix(key, c.db.cfg.Separator) {
 			node.next.Delete(pos[len(pos)-1])
 		} else {
-			target.(*storageNode).removeValue(c.db.buildStorageKey(key))
+			target.(*storageNode).removeValue(c.db.buildStorageKey(key), revision)
 		}
 	} else {
 		// in current layer
-		node.removeValue(c.db.buildStorageKey(key))
+		node.removeValue(c.db.buildStorageKey(key), revision)
 	}
 }
 
@@ -192,16 +262,32 @@ func (c *cachedStorage) buildCache(ctx context.Context) {
 
 		node.appendValues(kv)
 	}
+	c.mu.Lock()
 	c.root = root
+	c.mu.Unlock()
+}
+
+func (c *cachedStorage) handleEvent(e Event, key string) {
+	c.handleEvents(storageEvent{Event: e, key: key})
 }
 
-func (c *cachedStorage) handleEvent(t EventType, key string, value interface{}) {
-	key = c.db.buildStorageKey(key)
-	switch t {
-	case EventTypeUpdate, EventTypeCreate:
-		c.setCache(key, value)
-	case EventTypeDelete:
-		c.remove(key)
+// handleEvents in one step, so that readers never see part of a transaction
+func (c *cachedStorage) handleEvents(es ...storageEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range es {
+		switch e.Type {
+		case EventTypeUpdate, EventTypeCreate:
+			kv := e.KV
+			kv.Key = c.db.buildStorageKey(e.key)
+			if kv.ModRevision == 0 {
+				kv.ModRevision = e.Revision
+			}
+			c.set(&kv)
+		case EventTypeDelete:
+			// delete expects key relative to storage
+			c.delete(e.key, e.Revision)
+		}
 	}
 }
 
@@ -211,25 +297,30 @@ func (n *storageNode) appendValues(vs ...*KV) {
 	n.values = append(n.values, vs...)
 }
 
-func (n *storageNode) updateOrInsertValue(key string, value interface{}) {
+// updateOrInsertValue ignores kv older than current, revision 0 is treated as latest
+func (n *storageNode) updateOrInsertValue(kv *KV) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	for i, kv := range n.values {
-		if kv.Key == key {
-			n.values[i].Value = g.NewVar(value)
+	for i, ori := range n.values {
+		if ori.Key == kv.Key {
+			if kv.ModRevision == 0 || kv.ModRevision >= ori.ModRevision {
+				n.values[i] = kv
+			}
 			return
 		}
 	}
 
-	n.values = append(n.values, &KV{Key: key, Value: g.NewVar(value)})
+	n.values = append(n.values, kv)
 }
 
-func (n *storageNode) removeValue(key string) {
+// removeValue ignores deletion older than current value, revision 0 is treated as latest
+func (n *storageNode) removeValue(key string, revision int64) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	for i, value := range n.values {
-		if value.Key == key {
+		if value.Key == key && (revision == 0 || revision >= value.ModRevision) {
 			n.values = append(n.values[:i], n.values[i+1:]...)
+			return
 		}
 	}
 }
@@ -37,10 +37,46 @@ func (s *storage) SetTTL(ctx context.Context, key string, value interface{}, ttl
 }
 
 func (s *storage) set(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
-	if !strings.HasPrefix(key, s.name) {
+	return s.Database.Set(ctx, s.fullKey(key), value, ttl, keepalive...)
+}
+
+func (s *storage) CompareAndSet(ctx context.Context, key string, cmp Compare, value interface{}) (err error) {
+	return s.Database.CompareAndSet(ctx, s.fullKey(key), cmp, value)
+}
+
+func (s *storage) Create(ctx context.Context, key string, value interface{}) (err error) {
+	return s.CreateTTL(ctx, key, value, 0)
+}
+
+func (s *storage) CreateTTL(ctx context.Context, key string, value interface{}, ttl int64, keepalive ...bool) (err error) {
+	return s.Database.Create(ctx, s.fullKey(key), value, ttl, keepalive...)
+}
+
+func (s *storage) Txn(ctx context.Context, guards []Guard, ops ...Op) (err error) {
+	_, err = s.txn(ctx, guards, ops...)
+	return
+}
+
+// txn with keys relative to storage
+func (s *storage) txn(ctx context.Context, guards []Guard, ops ...Op) (revision int64, err error) {
+	fullGuards := make([]Guard, 0, len(guards))
+	for _, guard := range guards {
+		fullGuards = append(fullGuards, Guard{Key: s.fullKey(guard.Key), Compare: guard.Compare})
+	}
+	fullOps := make([]Op, 0, len(ops))
+	for _, op := range ops {
+		op.key = s.fullKey(op.key)
+		fullOps = append(fullOps, op)
+	}
+	return s.Database.Txn(ctx, fullGuards, fullOps...)
+}
+
+// fullKey with storage prefix if not built
+func (s *storage) fullKey(key string) string {
+	if !strings.HasPrefix(key, s.buildStorageKey()) {
 		key = s.buildStorageKey(key)
 	}
-	return s.Database.Set(ctx, key, value, ttl, keepalive...)
+	return key
 }
 
 func (s *storage) Delete(ctx context.Context, key string) (err error) {
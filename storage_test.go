@@ -2,9 +2,12 @@ package simple_registry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
+
+	"github.com/gogf/gf/v2/frame/g"
 )
 
 func dfs(node *storageNode) {
@@ -20,6 +23,7 @@ func dfs(node *storageNode) {
 	})
 }
 func TestCachedStorage(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig())
 	if err != nil {
 		t.Fatal(err)
@@ -150,17 +154,28 @@ func TestCachedStorage(t *testing.T) {
 }
 
 func TestEvent(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig())
 	if err != nil {
 		t.Fatal(err)
 		return
 	}
 
+	// local copy, globals are reset by following tests
+	sto := Storages.GetStorage("test").(*cachedStorage)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
 	go func() {
 		for {
-			dfs(Storages.GetStorage("test").(*cachedStorage).root)
+			sto.mu.RLock()
+			dfs(sto.root)
+			sto.mu.RUnlock()
 			fmt.Println("---------------------------")
-			time.Sleep(time.Second * 5)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second * 5):
+			}
 		}
 	}()
 
@@ -168,6 +183,7 @@ func TestEvent(t *testing.T) {
 }
 
 func TestStorage_SetTTL(t *testing.T) {
+	resetGlobals()
 	err := Init(context.Background(), getConfig())
 	if err != nil {
 		t.Fatal(err)
@@ -228,3 +244,202 @@ func TestStorage_SetTTL(t *testing.T) {
 		return
 	}
 }
+
+func TestStorageResync(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto := cli.Storages.GetStorage("test")
+	if err = sto.Set(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// change database silently as if watch events lost
+	cs := sto.(*cachedStorage)
+	store := cs.db.Database.(*memory).store
+	store.mu.Lock()
+	delete(store.kvs, cs.db.buildStorageKey("key"))
+	store.kvs[cs.db.buildStorageKey("key1")] = &memoryValue{value: "value1"}
+	store.mu.Unlock()
+
+	resynced := false
+	cli.Storages.SetResyncHandler("test", func() {
+		resynced = true
+	})
+	cli.Storages.handleEvent(ctx, Event{KV: KV{Key: cli.Storages.cfg.getStoragePrefix()}, Type: EventTypeResync})
+	if !resynced {
+		t.Fatal("resync handler not called")
+	}
+
+	kvs, err := sto.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 1 || kvs[0].Key != cs.db.buildStorageKey("key1") {
+		t.Fatalf("cache not rebuilt: %+v", kvs)
+	}
+}
+
+func TestStorage_CompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto := cli.Storages.GetStorage("test")
+	if err = sto.Create(ctx, "flag", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sto.Create(ctx, "flag", "off"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+
+	// optimistic update by revision read from cache
+	kvs, err := sto.Get(ctx, "flag")
+	if err != nil || len(kvs) != 1 || kvs[0].ModRevision == 0 {
+		t.Fatalf("unexpected kvs: %v %+v", err, kvs)
+	}
+	rev := kvs[0].ModRevision
+	if err = cli.Storages.GetStorage("test", true).Set(ctx, "flag", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sto.CompareAndSet(ctx, "flag", CompareRevision(rev), "off"); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expect conflict, got %v", err)
+	}
+	if err = sto.CompareAndSet(ctx, "flag", CompareValue("changed"), "off"); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ = sto.Get(ctx, "flag"); kvs[0].Value.String() != "off" || kvs[0].ModRevision <= rev {
+		t.Fatalf("cache not updated: %+v", kvs[0])
+	}
+}
+
+func TestStorage_Txn(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto := cli.Storages.GetStorage("test")
+	if err = sto.Txn(ctx, []Guard{{Key: "flag", Compare: CompareRevision(0)}},
+		OpSet("flag", "on"), OpSet("rollout", 0), OpSet("legacy/a", 1), OpSet("legacy/b", 1)); err != nil {
+		t.Fatal(err)
+	}
+	kvs, _ := sto.Get(ctx)
+	if len(kvs) != 4 || kvs[0].ModRevision == 0 {
+		t.Fatalf("unexpected kvs: %+v", kvs)
+	}
+	for _, kv := range kvs {
+		if kv.ModRevision != kvs[0].ModRevision {
+			t.Fatalf("revision of txn not shared: %+v", kvs)
+		}
+	}
+
+	// not matched guard changes nothing
+	var ce *ConflictError
+	err = sto.Txn(ctx, []Guard{{Key: "flag", Compare: CompareValue("on")}, {Key: "rollout", Compare: CompareValue(50)}},
+		OpSet("rollout", 100), OpDelete("flag"))
+	if !errors.As(err, &ce) || ce.Key != cli.Storages.cfg.getStoragePrefix()+"test/rollout" || ce.Current.Value.Int() != 0 {
+		t.Fatalf("expect conflict of rollout, got %v", err)
+	}
+	if kvs, _ = sto.Get(ctx); len(kvs) != 4 {
+		t.Fatalf("unexpected kvs after conflict: %+v", kvs)
+	}
+
+	// readers never see half of txn, neither of replica applying events
+	peer, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica := peer.Storages.GetStorage("test")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			if err := sto.Txn(ctx, nil, OpSet("flag", i), OpSet("rollout", i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, s := range []Storage{sto, replica} {
+			flag, _ := s.Get(ctx, "flag")
+			rollout, _ := s.Get(ctx, "rollout")
+			if flag[0].Value.Int() > rollout[0].Value.Int() {
+				t.Fatalf("half of txn applied: flag=%s rollout=%s", flag[0].Value, rollout[0].Value)
+			}
+		}
+	}
+
+	if err = sto.Txn(ctx, nil, OpDelete("legacy/"), OpDelete("flag")); err != nil {
+		t.Fatal(err)
+	}
+	if kvs, _ = sto.Get(ctx); len(kvs) != 1 || kvs[0].Value.Int() != 100 {
+		t.Fatalf("unexpected kvs after delete: %+v", kvs)
+	}
+}
+
+func TestStorage_StaleEvent(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto := cli.Storages.GetStorage("test")
+	cs := sto.(*cachedStorage)
+	for _, v := range []string{"old", "new"} {
+		if err = sto.Set(ctx, "key", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs, _ := sto.Get(ctx, "key")
+	rev := kvs[0].ModRevision
+	if rev == 0 {
+		t.Fatal("revision of local set unknown")
+	}
+
+	// events older than local set arrive late
+	stale := KV{Key: cs.db.buildStorageKey("key"), Value: g.NewVar("old"), ModRevision: rev - 1}
+	cs.handleEvent(Event{KV: stale, Type: EventTypeUpdate, Revision: rev - 1}, "key")
+	cs.handleEvent(Event{KV: stale, Type: EventTypeDelete, Revision: rev - 1}, "key")
+	if kvs, _ = sto.Get(ctx, "key"); len(kvs) != 1 || kvs[0].Value.String() != "new" {
+		t.Fatalf("stale event applied: %+v", kvs)
+	}
+
+	// newer event applied
+	cs.handleEvent(Event{KV: KV{Key: stale.Key, Value: g.NewVar("newer"), ModRevision: rev + 1}, Type: EventTypeUpdate, Revision: rev + 1}, "key")
+	if kvs, _ = sto.Get(ctx, "key"); kvs[0].Value.String() != "newer" {
+		t.Fatalf("newer event not applied: %+v", kvs)
+	}
+}
+
+func TestStorage_TxnEvents(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sto := cli.Storages.GetStorage("test")
+	pfx := cli.Storages.cfg.getStoragePrefix() + "test/"
+	event := func(key string, more bool) Event {
+		return Event{KV: KV{Key: pfx + key, Value: g.NewVar(1), ModRevision: 100}, Type: EventTypeCreate, Revision: 100, More: more}
+	}
+
+	// events of one revision applied after the last arrived
+	cli.Storages.handleEvent(ctx, event("a", true))
+	if kvs, _ := sto.Get(ctx); len(kvs) != 0 {
+		t.Fatalf("part of txn applied: %+v", kvs)
+	}
+	cli.Storages.handleEvent(ctx, event("b", false))
+	if kvs, _ := sto.Get(ctx); len(kvs) != 2 {
+		t.Fatalf("txn not applied: %+v", kvs)
+	}
+}
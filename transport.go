@@ -0,0 +1,163 @@
+package simple_registry
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gogf/gf/v2/frame/g"
+)
+
+const defaultTransportRetries = 2
+
+type (
+	// TransportConfig for Transport
+	TransportConfig struct {
+		Picker    PickerConfig `json:"picker"`
+		Retries   int          `json:"retries"`    // retry on other instances when connection failed, default 2, -1 to disable
+		KeyHeader string       `json:"key_header"` // request header used as pick key, e.g. consistent hash picker
+	}
+	// Transport is a http.RoundTripper resolves request host like "http://service-name/..."
+	// to instance address picked from registry, hosts not registered are passed to base directly.
+	Transport struct {
+		reg     Interface
+		base    http.RoundTripper
+		cfg     TransportConfig
+		mu      sync.Mutex
+		pickers map[string]Picker // service_name : Picker
+	}
+)
+
+func (c *TransportConfig) check() {
+	c.Picker.check()
+	if c.Retries == 0 {
+		c.Retries = defaultTransportRetries
+	}
+	if c.Retries < 0 {
+		c.Retries = 0
+	}
+}
+
+// NewTransport based on base, http.DefaultTransport if base is nil
+func NewTransport(reg Interface, base http.RoundTripper, config ...TransportConfig) (t *Transport, err error) {
+	cfg := TransportConfig{}
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.check()
+	// validate picker config
+	if _, err = NewPicker(reg, "", cfg.Picker); err != nil {
+		return
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t = &Transport{reg: reg, base: base, cfg: cfg, pickers: make(map[string]Picker)}
+	return
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	ctx := req.Context()
+	name := req.URL.Hostname()
+	if req.URL.Port() != "" {
+		return t.base.RoundTrip(req)
+	}
+	// base closes body once called, close it if failed before sent
+	sent := false
+	defer func() {
+		if err != nil && !sent && req.Body != nil {
+			_ = req.Body.Close()
+		}
+	}()
+	service, err := t.reg.GetService(ctx, name)
+	if err != nil {
+		if errors.Is(err, ErrServiceNotFound) {
+			sent = true
+			return t.base.RoundTrip(req)
+		}
+		return
+	}
+	p, err := t.picker(name)
+	if err != nil {
+		return
+	}
+
+	tried := make(map[string]struct{})
+	for attempt := 0; attempt <= t.cfg.Retries; attempt++ {
+		ins, pErr := p.Pick(ctx, req.Header.Get(t.cfg.KeyHeader))
+		if pErr != nil {
+			// dial error caused the retry is the real failure
+			if err == nil {
+				err = pErr
+			}
+			return nil, err
+		}
+		if _, ok := tried[ins.Identity()]; ok {
+			// picked again, try any instance not tried yet, returns the last error if all tried
+			if ins = untried(service, tried); ins == nil {
+				return
+			}
+		}
+		tried[ins.Identity()] = struct{}{}
+
+		var out *http.Request
+		if out, err = rewrite(req, ins, attempt > 0); err != nil {
+			return
+		}
+		sent = true
+		if resp, err = t.base.RoundTrip(out); err == nil || !isDialError(err) || !rewindable(req) {
+			return
+		}
+		g.Log().Warningf(ctx, "transport failed to connect %s of %s: %v", out.URL.Host, name, err)
+	}
+	return
+}
+
+// picker of service, created on demand
+func (t *Transport) picker(name string) (p Picker, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p = t.pickers[name]; p != nil {
+		return
+	}
+	if p, err = NewPicker(t.reg, name, t.cfg.Picker); err != nil {
+		return
+	}
+	t.pickers[name] = p
+	return
+}
+
+// rewrite request host to instance address, body is renewed on retry
+func rewrite(req *http.Request, ins *Instance, retry bool) (out *http.Request, err error) {
+	out = req.Clone(req.Context())
+	out.URL.Host = net.JoinHostPort(ins.Host, strconv.Itoa(ins.Port))
+	if retry && req.GetBody != nil {
+		if out.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// rewindable request body can be sent again
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// untried instance of service, nil if all tried
+func untried(service *Service, tried map[string]struct{}) *Instance {
+	for _, instance := range service.ServingInstances() {
+		if _, ok := tried[instance.Identity()]; !ok {
+			return instance
+		}
+	}
+	return nil
+}
+
+// isDialError means connection not established and request not sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
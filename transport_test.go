@@ -0,0 +1,118 @@
+package simple_registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func registerServer(t *testing.T, cli *Client, name, addr string) {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := net.LookupPort("tcp", port)
+	if _, err := cli.Registry.Register(context.Background(), NewInstance(name).WithAddress(host, p)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.URL.Path + ":" + string(body)))
+	}))
+	defer srv.Close()
+	// closed server, connection refused
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	registerServer(t, cli, "order-service", dead.Listener.Addr().String())
+	registerServer(t, cli, "order-service", srv.Listener.Addr().String())
+	time.Sleep(time.Millisecond * 100)
+
+	tr, err := NewTransport(cli.Registry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := &http.Client{Transport: tr}
+	// dead instance picked in turn, retried on another
+	for i := 0; i < 4; i++ {
+		resp, err := hc.Post("http://order-service/orders", "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "/orders:body" {
+			t.Fatalf("unexpected response: %s", body)
+		}
+	}
+
+	// not registered host passed through
+	resp, err := hc.Get(srv.URL + "/direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// retry disabled, dead instance fails
+	tr, _ = NewTransport(cli.Registry, nil, TransportConfig{Retries: -1})
+	hc = &http.Client{Transport: tr}
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if resp, err = hc.Get("http://order-service/orders"); err != nil {
+			failed++
+			continue
+		}
+		_ = resp.Body.Close()
+	}
+	if failed != 1 {
+		t.Fatalf("expect one failure without retry, got %d", failed)
+	}
+}
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransport_CloseBody(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerServer(t, cli, "order-service", "127.0.0.1:8080")
+	time.Sleep(time.Millisecond * 100)
+
+	// pick key missing
+	tr, err := NewTransport(cli.Registry, nil, TransportConfig{
+		Picker:    PickerConfig{Strategy: PickerConsistentHash},
+		KeyHeader: "X-Key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := &closeTracker{Reader: strings.NewReader("body")}
+	req, _ := http.NewRequest(http.MethodPost, "http://order-service/orders", body)
+	if _, err = tr.RoundTrip(req); !errors.Is(err, ErrPickKeyRequired) {
+		t.Fatalf("expect pick key required, got %v", err)
+	}
+	if !body.closed {
+		t.Fatal("request body not closed on error")
+	}
+}
@@ -0,0 +1,190 @@
+package simple_registry
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gogf/gf/v2/util/gconv"
+)
+
+type (
+	// ServiceEvent of instance change delivered by Interface.Watch
+	ServiceEvent struct {
+		Type        EventType
+		ServiceName string
+		Old         *Instance // nil if created
+		New         *Instance // nil if deleted
+		Revision    int64     // revision of database, 0 if local change or not supported
+		Time        time.Time
+	}
+	// EventFilter of subscription, empty conditions match all, conditions are combined with AND
+	EventFilter struct {
+		ServiceNames    []string          `json:"service_names"`    // any of service names
+		ServicePrefixes []string          `json:"service_prefixes"` // any of service name prefixes
+		Labels          map[string]string `json:"labels"`           // all labels equal to meta of old or new instance
+		Selector        Selector          `json:"-"`                // selector matched old or new instance
+	}
+	// subscriber of events, each one has its own queue and goroutine delivering in order
+	subscriber struct {
+		filter  EventFilter
+		queue   *eventQueue
+		deliver func(e ServiceEvent) bool // returns false to stop delivery
+	}
+)
+
+func (r *registry) Watch(ctx context.Context, serviceName ...string) <-chan ServiceEvent {
+	return r.WatchWithFilter(ctx, EventFilter{ServiceNames: serviceName})
+}
+
+func (r *registry) WatchWithFilter(ctx context.Context, filter EventFilter) <-chan ServiceEvent {
+	ch := make(chan ServiceEvent)
+	s := &subscriber{
+		filter: filter,
+		queue:  newEventQueue(r.cfg.Delivery),
+		deliver: func(e ServiceEvent) bool {
+			select {
+			case ch <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		},
+	}
+	done := r.subscribe(s)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		r.unsubscribe(s)
+		<-done
+		close(ch)
+	}()
+	return ch
+}
+
+func (r *registry) RegisterEventHandler(handler EventHandler, config ...DeliveryConfig) (unsubscribe func()) {
+	return r.RegisterFilteredEventHandler(EventFilter{}, handler, config...)
+}
+
+func (r *registry) RegisterServiceEventHandler(serviceName string, handler EventHandler, config ...DeliveryConfig) (unsubscribe func()) {
+	return r.RegisterFilteredEventHandler(EventFilter{ServiceNames: []string{serviceName}}, handler, config...)
+}
+
+func (r *registry) RegisterFilteredEventHandler(filter EventFilter, handler EventHandler, config ...DeliveryConfig) (unsubscribe func()) {
+	cfg := r.cfg.Delivery
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	s := &subscriber{
+		filter: filter,
+		queue:  newEventQueue(cfg),
+		deliver: func(e ServiceEvent) bool {
+			// handlers receive deleted instance on delete, skip if unknown
+			target := e.New
+			if target == nil {
+				target = e.Old
+			}
+			if target != nil {
+				handler(target, e.Type)
+			}
+			return true
+		},
+	}
+	r.subscribe(s)
+	return func() { r.unsubscribe(s) }
+}
+
+// subscribe and start delivery, done is closed after delivery stopped
+func (r *registry) subscribe(s *subscriber) (done chan struct{}) {
+	r.subsMu.Lock()
+	r.subs[s] = struct{}{}
+	r.subsMu.Unlock()
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			e, ok := s.queue.pop()
+			if !ok || !s.deliver(e) {
+				return
+			}
+		}
+	}()
+	return
+}
+
+func (r *registry) unsubscribe(s *subscriber) {
+	r.subsMu.Lock()
+	delete(r.subs, s)
+	r.subsMu.Unlock()
+	s.queue.close()
+}
+
+// notify subscribers of instance change
+func (r *registry) notify(serviceName string, typ EventType, old, ins *Instance, rev int64) {
+	e := ServiceEvent{
+		Type:        typ,
+		ServiceName: serviceName,
+		Old:         old,
+		New:         ins,
+		Revision:    rev,
+		Time:        time.Now(),
+	}
+	r.subsMu.RLock()
+	subs := make([]*subscriber, 0, len(r.subs))
+	for s := range r.subs {
+		subs = append(subs, s)
+	}
+	r.subsMu.RUnlock()
+
+	// push without lock, blocked push won't block unsubscribe
+	for _, s := range subs {
+		if s.filter.match(e) {
+			s.queue.push(e.clone())
+		}
+	}
+}
+
+func (e ServiceEvent) clone() ServiceEvent {
+	if e.Old != nil {
+		e.Old = e.Old.clone()
+	}
+	if e.New != nil {
+		e.New = e.New.clone()
+	}
+	return e
+}
+
+func (f EventFilter) match(e ServiceEvent) bool {
+	if len(f.ServiceNames) > 0 && !slices.Contains(f.ServiceNames, e.ServiceName) {
+		return false
+	}
+	if len(f.ServicePrefixes) > 0 && !slices.ContainsFunc(f.ServicePrefixes, func(prefix string) bool {
+		return strings.HasPrefix(e.ServiceName, prefix)
+	}) {
+		return false
+	}
+	if len(f.Labels) > 0 && !f.matchLabels(e.Old) && !f.matchLabels(e.New) {
+		return false
+	}
+	if len(f.Selector) > 0 && !(e.Old != nil && f.Selector.Matches(e.Old)) && !(e.New != nil && f.Selector.Matches(e.New)) {
+		return false
+	}
+	return true
+}
+
+func (f EventFilter) matchLabels(ins *Instance) bool {
+	if ins == nil {
+		return false
+	}
+	for k, v := range f.Labels {
+		mv, ok := ins.Meta[k]
+		if !ok || gconv.String(mv) != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package simple_registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch := cli.Registry.Watch(watchCtx, "svc")
+	all := cli.Registry.Watch(watchCtx)
+
+	rg, err := cli.Registry.Register(ctx, NewInstance("svc").WithMetaData(map[string]interface{}{"version": "v1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.Registry.Register(ctx, NewInstance("other")); err != nil {
+		t.Fatal(err)
+	}
+	if err = rg.UpdateMeta(ctx, map[string]interface{}{"version": "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = rg.Deregister(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// instance not in cache deleted
+	r := cli.Registry.(*registry)
+	key := r.cfg.getRegistryPrefix() + "svc/unknown@127.0.0.1"
+	_ = r.cli.Set(ctx, key, "invalid", 0)
+	_ = r.cli.Delete(ctx, key)
+
+	next := func(ch <-chan ServiceEvent) ServiceEvent {
+		t.Helper()
+		select {
+		case e := <-ch:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("wait event timeout")
+		}
+		return ServiceEvent{}
+	}
+	var rev int64
+	check := func(e ServiceEvent, typ EventType, old, new string) {
+		t.Helper()
+		version := func(i *Instance) string {
+			if i == nil {
+				return ""
+			}
+			return i.Meta["version"].(string)
+		}
+		if e.Type != typ || e.ServiceName != "svc" || version(e.Old) != old || version(e.New) != new {
+			t.Fatalf("unexpected event: %s %s old=%+v new=%+v", e.Type, e.ServiceName, e.Old, e.New)
+		}
+		if e.Revision <= rev || e.Time.IsZero() {
+			t.Fatalf("unexpected revision %d or time %v", e.Revision, e.Time)
+		}
+		rev = e.Revision
+	}
+	check(next(ch), EventTypeCreate, "", "v1")
+	check(next(ch), EventTypeUpdate, "v1", "v2")
+	check(next(ch), EventTypeDelete, "v2", "")
+	if e := next(ch); e.Type != EventTypeDelete || e.ServiceName != "svc" || e.Old != nil {
+		t.Fatalf("unexpected event of uncached instance: %+v", e)
+	}
+
+	names := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		names[next(all).ServiceName]++
+	}
+	if names["svc"] != 4 || names["other"] != 1 {
+		t.Fatalf("unexpected events of all services: %v", names)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("unexpected event after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	ctx := context.Background()
+	cli, err := New(ctx, Config{Type: TypeMemory, Database: DatabaseConfig{Endpoints: []string{t.Name()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect := func() (chan string, EventHandler) {
+		ch := make(chan string, 10)
+		return ch, func(i *Instance, _ EventType) { ch <- i.ServiceName }
+	}
+	service, serviceHandler := collect()
+	prefix, prefixHandler := collect()
+	label, labelHandler := collect()
+	cli.Registry.RegisterServiceEventHandler("order", serviceHandler)
+	cli.Registry.RegisterFilteredEventHandler(EventFilter{ServicePrefixes: []string{"order"}}, prefixHandler)
+	cli.Registry.RegisterFilteredEventHandler(EventFilter{Labels: map[string]string{"env": "prod"}}, labelHandler)
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watch := cli.Registry.WatchWithFilter(watchCtx, EventFilter{
+		Labels:   map[string]string{"env": "prod"},
+		Selector: MustParseSelector("env in (prod),!worker"),
+	})
+
+	for name, env := range map[string]string{"order": "test", "order-worker": "prod", "user": "prod"} {
+		meta := map[string]interface{}{"env": env}
+		if name == "order-worker" {
+			meta["worker"] = true
+		}
+		if _, err = cli.Registry.Register(ctx, NewInstance(name).WithMetaData(meta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	received := func(ch chan string) map[string]bool {
+		m := make(map[string]bool)
+		for len(ch) > 0 {
+			m[<-ch] = true
+		}
+		return m
+	}
+	if m := received(service); len(m) != 1 || !m["order"] {
+		t.Fatalf("service handler received: %v", m)
+	}
+	if m := received(prefix); len(m) != 2 || !m["order"] || !m["order-worker"] {
+		t.Fatalf("prefix handler received: %v", m)
+	}
+	if m := received(label); len(m) != 2 || !m["order-worker"] || !m["user"] {
+		t.Fatalf("label handler received: %v", m)
+	}
+	select {
+	case e := <-watch:
+		if e.ServiceName != "user" {
+			t.Fatalf("unexpected watch event: %s", e.ServiceName)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait watch event timeout")
+	}
+}